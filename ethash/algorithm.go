@@ -17,6 +17,7 @@
 package ethash
 
 import (
+	"bytes"
 	"encoding/binary"
 	"hash"
 	"lukechampine.com/blake3"
@@ -130,6 +131,20 @@ func seedHash(block uint64) []byte {
 	return seed
 }
 
+// seedEpoch is the reverse of seedHash, returning the epoch a seed belongs to
+// and whether it was found among the first maxEpoch epochs at all.
+func seedEpoch(seed []byte) (uint64, bool) {
+	current := make([]byte, 32)
+	keccak256 := makeHasher(sha3.NewLegacyKeccak256())
+	for epoch := uint64(0); epoch < maxEpoch; epoch++ {
+		if bytes.Equal(current, seed) {
+			return epoch, true
+		}
+		keccak256(current, current)
+	}
+	return 0, false
+}
+
 // generateCache creates a verification cache of a given size for an input seed.
 // The cache production process involves first sequentially filling up 32 MB of
 // memory, then performing two passes of Sergio Demian Lerner's RandMemoHash
@@ -407,6 +422,91 @@ func hashimotoFull(dataset []uint32, hash []byte, nonce uint64) ([]byte, []byte)
 	return hashimoto(hash, nonce, uint64(len(dataset))*4, lookup)
 }
 
+// hashimotoContext holds the scratch buffers of a nonce search thread, so that
+// hashing nonces over the full dataset doesn't allocate.
+type hashimotoContext struct {
+	seed   [40]byte                            // Header hash and nonce
+	mix    [mixBytes / 4]uint32                // Mix, compressed in place into its first quarter
+	final  [hashBytes + common.HashLength]byte // Seed hash followed by the digest
+	result [common.HashLength]byte
+}
+
+// hashimotoFull is hashimotoFull on the buffers of the context, producing the
+// same digest and result. The returned slices point into the context and are
+// only valid until the next call.
+func (c *hashimotoContext) hashimotoFull(dataset []uint32, hash []byte, nonce uint64) ([]byte, []byte) {
+	// Calculate the number of theoretical rows (we use one buffer nonetheless)
+	rows := uint32(uint64(len(dataset)) * 4 / mixBytes)
+
+	// Combine header+nonce into a 64 byte seed
+	copy(c.seed[:], hash)
+	binary.LittleEndian.PutUint64(c.seed[32:], nonce)
+
+	seed := c.final[:hashBytes]
+	b64 := blake3.Sum512(c.seed[:])
+	copy(seed, b64[:])
+	seedHead := binary.LittleEndian.Uint32(seed)
+
+	// Start the mix with replicated seed
+	mix := c.mix[:]
+	for i := 0; i < len(mix); i++ {
+		mix[i] = binary.LittleEndian.Uint32(seed[i%16*4:])
+	}
+	// Mix in random dataset nodes, the rows are consecutive in the dataset
+	for i := 0; i < loopAccesses; i++ {
+		parent := fnv(uint32(i)^seedHead, mix[i%len(mix)]) % rows
+		fnvHash(mix, dataset[2*parent*hashWords:][:len(mix)])
+	}
+	// Compress mix
+	for i := 0; i < len(mix); i += 4 {
+		mix[i/4] = fnv(fnv(fnv(mix[i], mix[i+1]), mix[i+2]), mix[i+3])
+	}
+	digest := c.final[hashBytes:]
+	for i, val := range mix[:len(mix)/4] {
+		binary.LittleEndian.PutUint32(digest[i*4:], val)
+	}
+	c.result = blake3.Sum256(c.final[:])
+	return digest, c.result[:]
+}
+
+// powTarget is the highest PoW result accepted for a difficulty, 2^256 divided
+// by the difficulty, as four big-endian 64 bit words, most significant first.
+type powTarget [4]uint64
+
+// newPowTarget computes the target of a positive difficulty. The target of
+// difficulty 1 doesn't fit 256 bits, it's capped to 2^256-1 which accepts all
+// results just the same.
+func newPowTarget(difficulty *big.Int) powTarget {
+	target := new(big.Int).Div(two256, difficulty)
+	if target.BitLen() > 256 {
+		target.Sub(two256, common.Big1)
+	}
+	var blob [32]byte
+	target.FillBytes(blob[:])
+
+	var t powTarget
+	for i := range t {
+		t[i] = binary.BigEndian.Uint64(blob[i*8:])
+	}
+	return t
+}
+
+// accepts reports whether a 32 byte big-endian PoW result is at most the target.
+func (t *powTarget) accepts(result []byte) bool {
+	_ = result[31] // Bounds check hint to the compiler
+
+	// Almost all results are rejected on the most significant word already
+	if word := binary.BigEndian.Uint64(result); word != t[0] {
+		return word < t[0]
+	}
+	for i := 1; i < len(t); i++ {
+		if word := binary.BigEndian.Uint64(result[i*8:]); word != t[i] {
+			return word < t[i]
+		}
+	}
+	return true
+}
+
 const maxEpoch = 2048
 
 // datasetSizes is a lookup table for the ethash dataset size for the first 2048
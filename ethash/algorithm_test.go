@@ -0,0 +1,112 @@
+package ethash
+
+import (
+	"bytes"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// testDataset creates a random dataset of the given number of mix sized rows.
+func testDataset(rnd *rand.Rand, rows int) []uint32 {
+	dataset := make([]uint32, rows*mixBytes/4)
+	for i := range dataset {
+		dataset[i] = rnd.Uint32()
+	}
+	return dataset
+}
+
+// Tests that hashing on the buffers of a search context produces the same
+// digests and results as the plain full dataset hashing.
+func TestHashimotoContext(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	dataset := testDataset(rnd, 4096)
+
+	var (
+		search = new(hashimotoContext)
+		hash   = make([]byte, 32)
+	)
+	for i := 0; i < 2000; i++ {
+		rnd.Read(hash)
+		nonce := rnd.Uint64()
+
+		wantDigest, wantResult := hashimotoFull(dataset, hash, nonce)
+		digest, result := search.hashimotoFull(dataset, hash, nonce)
+		if !bytes.Equal(digest, wantDigest) {
+			t.Fatalf("hash %x, nonce %d: digest mismatch: have %x, want %x", hash, nonce, digest, wantDigest)
+		}
+		if !bytes.Equal(result, wantResult) {
+			t.Fatalf("hash %x, nonce %d: result mismatch: have %x, want %x", hash, nonce, result, wantResult)
+		}
+	}
+}
+
+// Tests that hashing on the buffers of a search context doesn't allocate.
+func TestHashimotoContextAllocs(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	dataset := testDataset(rnd, 4096)
+
+	var (
+		search = new(hashimotoContext)
+		hash   = make([]byte, 32)
+		nonce  uint64
+	)
+	rnd.Read(hash)
+	allocs := testing.AllocsPerRun(100, func() {
+		search.hashimotoFull(dataset, hash, nonce)
+		nonce++
+	})
+	if allocs != 0 {
+		t.Errorf("allocations per hash: have %v, want 0", allocs)
+	}
+}
+
+// Tests that fixed width targets accept exactly the results at most 2^256 divided
+// by the difficulty, as compared with big integers, at and around the boundary.
+func TestPowTarget(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	difficulties := []*big.Int{common.Big1, common.Big2, common.Big3, big.NewInt(1000), new(big.Int).Sub(two256, common.Big1)}
+	for i := 0; i < 500; i++ {
+		difficulty := new(big.Int).Rand(rnd, new(big.Int).Lsh(common.Big1, uint(1+rnd.Intn(256))))
+		difficulties = append(difficulties, difficulty.Add(difficulty, common.Big1))
+	}
+	maxResult := new(big.Int).Sub(two256, common.Big1)
+	for _, difficulty := range difficulties {
+		var (
+			target  = newPowTarget(difficulty)
+			bound   = new(big.Int).Div(two256, difficulty)
+			results []*big.Int
+		)
+		for delta := int64(-2); delta <= 2; delta++ {
+			results = append(results, new(big.Int).Add(bound, big.NewInt(delta)))
+		}
+		results = append(results, new(big.Int), maxResult, new(big.Int).Rand(rnd, two256))
+		for _, result := range results {
+			if result.Sign() < 0 || result.Cmp(maxResult) > 0 {
+				continue
+			}
+			blob := make([]byte, 32)
+			result.FillBytes(blob)
+			if have, want := target.accepts(blob), result.Cmp(bound) <= 0; have != want {
+				t.Fatalf("difficulty %v, result %x: accepted %v, want %v", difficulty, blob, have, want)
+			}
+		}
+	}
+}
+
+// Benchmarks hashing on the buffers of a search context.
+func BenchmarkHashimotoContext(b *testing.B) {
+	rnd := rand.New(rand.NewSource(1))
+	dataset := testDataset(rnd, 4096)
+	search := new(hashimotoContext)
+	hash := make([]byte, 32)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		search.hashimotoFull(dataset, hash, uint64(i))
+	}
+}
@@ -0,0 +1,319 @@
+package ethash
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"golang.org/x/crypto/sha3"
+)
+
+var (
+	errWorkMismatch      = errors.New("work does not match the node's pending block")
+	errAuditInconclusive = errors.New("audit inconclusive")
+	errPendingChanged    = fmt.Errorf("%w: pending block changed during the audit", errAuditInconclusive)
+)
+
+// auditRules are the difficulty rules of the chain given with -auditgenesis, nil
+// to only check the difficulty on chains with built-in rules.
+var auditRules *difficultyRules
+
+// difficultyRules are the fork blocks of a chain that change how the ethash
+// difficulty is calculated, as in the config of a geth genesis file.
+type difficultyRules struct {
+	ChainID             *big.Int `json:"chainId"`
+	HomesteadBlock      *big.Int `json:"homesteadBlock"`
+	ByzantiumBlock      *big.Int `json:"byzantiumBlock"`
+	ConstantinopleBlock *big.Int `json:"constantinopleBlock"`
+	MuirGlacierBlock    *big.Int `json:"muirGlacierBlock"`
+	LondonBlock         *big.Int `json:"londonBlock"`
+	ArrowGlacierBlock   *big.Int `json:"arrowGlacierBlock"`
+	GrayGlacierBlock    *big.Int `json:"grayGlacierBlock"`
+}
+
+// mainnetDifficultyRules are the difficulty rules of the Ethereum mainnet.
+var mainnetDifficultyRules = &difficultyRules{
+	ChainID:             big.NewInt(1),
+	HomesteadBlock:      big.NewInt(1150000),
+	ByzantiumBlock:      big.NewInt(4370000),
+	ConstantinopleBlock: big.NewInt(7280000),
+	MuirGlacierBlock:    big.NewInt(9200000),
+	LondonBlock:         big.NewInt(12965000),
+	ArrowGlacierBlock:   big.NewInt(13773000),
+	GrayGlacierBlock:    big.NewInt(15050000),
+}
+
+// loadDifficultyRules reads the difficulty rules from a geth genesis file, or a
+// file with just its config.
+func loadDifficultyRules(path string) (*difficultyRules, error) {
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var genesis struct {
+		Config *difficultyRules `json:"config"`
+	}
+	if err := json.Unmarshal(blob, &genesis); err != nil {
+		return nil, err
+	}
+	if genesis.Config != nil {
+		return genesis.Config, nil
+	}
+	rules := new(difficultyRules)
+	if err := json.Unmarshal(blob, rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// calcDifficulty is CalcDifficulty with the fork rules of the chain.
+func (r *difficultyRules) calcDifficulty(time uint64, parent *types.Header) *big.Int {
+	next := new(big.Int).Add(parent.Number, big1)
+	active := func(fork *big.Int) bool {
+		return fork != nil && fork.Cmp(next) <= 0
+	}
+	switch {
+	case active(r.GrayGlacierBlock):
+		return calcDifficultyEip5133(time, parent)
+	case active(r.ArrowGlacierBlock):
+		return calcDifficultyEip4345(time, parent)
+	case active(r.LondonBlock):
+		return calcDifficultyEip3554(time, parent)
+	case active(r.MuirGlacierBlock):
+		return calcDifficultyEip2384(time, parent)
+	case active(r.ConstantinopleBlock):
+		return calcDifficultyConstantinople(time, parent)
+	case active(r.ByzantiumBlock):
+		return calcDifficultyByzantium(time, parent)
+	case active(r.HomesteadBlock):
+		return calcDifficultyHomestead(time, parent)
+	default:
+		return calcDifficultyFrontier(time, parent)
+	}
+}
+
+// auditFields are the fields of a block as returned by eth_getBlockByNumber that
+// are either part of the seal hash or not meant to be. Headers with any other
+// field, like the withdrawals of proof-of-stake chains, have a seal hash this
+// miner can't compute, so their work can't be audited.
+var auditFields = map[string]bool{
+	"parentHash": true, "sha3Uncles": true, "miner": true, "stateRoot": true,
+	"transactionsRoot": true, "receiptsRoot": true, "logsBloom": true, "difficulty": true,
+	"number": true, "gasLimit": true, "gasUsed": true, "timestamp": true, "extraData": true,
+	"baseFeePerGas": true, "hash": true, "nonce": true, "mixHash": true, "size": true,
+	"totalDifficulty": true, "transactions": true, "uncles": true,
+}
+
+var (
+	auditUnsupported      sync.Once // Logs once that the node's headers can't be audited
+	difficultyUnsupported sync.Once // Logs once that the chain's difficulty can't be checked
+)
+
+// auditHeader is a block header as returned by eth_getBlockByNumber. Nodes leave
+// out the miner of the pending block, so unlike types.Header it's optional.
+type auditHeader struct {
+	ParentHash  common.Hash     `json:"parentHash"`
+	UncleHash   common.Hash     `json:"sha3Uncles"`
+	Coinbase    *common.Address `json:"miner"`
+	Root        common.Hash     `json:"stateRoot"`
+	TxHash      common.Hash     `json:"transactionsRoot"`
+	ReceiptHash common.Hash     `json:"receiptsRoot"`
+	Bloom       types.Bloom     `json:"logsBloom"`
+	Difficulty  *hexutil.Big    `json:"difficulty"`
+	Number      *hexutil.Big    `json:"number"`
+	GasLimit    hexutil.Uint64  `json:"gasLimit"`
+	GasUsed     hexutil.Uint64  `json:"gasUsed"`
+	Time        hexutil.Uint64  `json:"timestamp"`
+	Extra       hexutil.Bytes   `json:"extraData"`
+	BaseFee     *hexutil.Big    `json:"baseFeePerGas"`
+}
+
+// auditedHeader is a block header with the base fee added since London, which
+// types.Header of this go-ethereum version lacks.
+type auditedHeader struct {
+	*types.Header
+	BaseFee *big.Int
+}
+
+// sealHash computes the seal hash of the header, covering the base fee if the
+// block has one, as go-ethereum does since London.
+func (h *auditedHeader) sealHash() common.Hash {
+	if h.BaseFee == nil {
+		return cpuHash.SealHash(h.Header)
+	}
+	var hash common.Hash
+	hasher := sha3.NewLegacyKeccak256()
+	rlp.Encode(hasher, []interface{}{
+		h.ParentHash,
+		h.UncleHash,
+		h.Coinbase,
+		h.Root,
+		h.TxHash,
+		h.ReceiptHash,
+		h.Bloom,
+		h.Difficulty,
+		h.Number,
+		h.GasLimit,
+		h.GasUsed,
+		h.Time,
+		h.Extra,
+		h.BaseFee,
+	})
+	hasher.Sum(hash[:0])
+	return hash
+}
+
+// fetchHeader retrieves a block header from the node with the given method.
+// Headers with fields the seal hash doesn't cover make the audit inconclusive.
+func fetchHeader(client *rpcClient, method string, block interface{}) (*auditedHeader, error) {
+	var blob json.RawMessage
+	if err := callNode(client, method, &blob, block, false); err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(blob, &fields); err != nil {
+		return nil, errInvalidResponse
+	}
+	for name, value := range fields {
+		if !auditFields[name] && string(value) != "null" {
+			return nil, fmt.Errorf("%w: header field %s is not supported", errAuditInconclusive, name)
+		}
+	}
+	var h auditHeader
+	if err := json.Unmarshal(blob, &h); err != nil {
+		return nil, errInvalidResponse
+	}
+	if h.Difficulty == nil || h.Number == nil {
+		return nil, errInvalidResponse
+	}
+	header := &types.Header{
+		ParentHash:  h.ParentHash,
+		UncleHash:   h.UncleHash,
+		Root:        h.Root,
+		TxHash:      h.TxHash,
+		ReceiptHash: h.ReceiptHash,
+		Bloom:       h.Bloom,
+		Difficulty:  h.Difficulty.ToInt(),
+		Number:      h.Number.ToInt(),
+		GasLimit:    uint64(h.GasLimit),
+		GasUsed:     uint64(h.GasUsed),
+		Time:        uint64(h.Time),
+		Extra:       h.Extra,
+	}
+	if h.Coinbase != nil {
+		header.Coinbase = *h.Coinbase
+	} else if err := callNode(client, "eth_coinbase", &header.Coinbase); err != nil {
+		return nil, err
+	}
+	audited := &auditedHeader{Header: header}
+	if h.BaseFee != nil {
+		audited.BaseFee = h.BaseFee.ToInt()
+	}
+	return audited, nil
+}
+
+// auditWork checks that the work handed out by the node is for its pending
+// block: the seal hash of the pending header has to match the header hash of
+// the work, its target has to match the block difficulty, and that has to be
+// the difficulty calculated from the parent. The difficulty rules are those
+// given with -auditgenesis, or built in for the Ethereum mainnet, the check is
+// skipped on other chains.
+func auditWork(client *rpcClient, work Work) error {
+	pending, err := fetchHeader(client, "eth_getBlockByNumber", "pending")
+	if err != nil {
+		return err
+	}
+	// The pending block moves on with new transactions, so it's only compared
+	// with the work as long as that didn't change in the meantime.
+	_, hash, err := GetWorkHead(client)
+	if err != nil {
+		return err
+	}
+	if common.HexToHash(hash) != common.HexToHash(work.Hash) || (work.exact && pending.Number.Cmp(work.Header.Number) != 0) {
+		return errPendingChanged
+	}
+	if sealHash := pending.sealHash(); sealHash != common.HexToHash(work.Hash) {
+		return fmt.Errorf("%w: header hash %s, pending block seal hash %s", errWorkMismatch, work.Hash, sealHash.Hex())
+	}
+	// The work only carries the target, so compare the difficulty it rounds to
+	target := new(big.Int).Div(two256, pending.Difficulty)
+	if want := new(big.Int).Div(two256, target); work.Header.Difficulty.Cmp(want) != 0 {
+		return fmt.Errorf("%w: target for difficulty %v, pending block difficulty %v", errWorkMismatch, work.Header.Difficulty, pending.Difficulty)
+	}
+	var chainID hexutil.Big
+	if err := callNode(client, "eth_chainId", &chainID); err != nil {
+		return err
+	}
+	rules := auditRules
+	if rules == nil && chainID.ToInt().Cmp(mainnetDifficultyRules.ChainID) == 0 {
+		rules = mainnetDifficultyRules
+	}
+	if rules == nil {
+		difficultyUnsupported.Do(func() {
+			log.Println("Not auditing the difficulty of chain", chainID.ToInt(), "without its rules, give its genesis file with -auditgenesis")
+		})
+		return nil
+	}
+	if rules.ChainID != nil && rules.ChainID.Cmp(chainID.ToInt()) != 0 {
+		return fmt.Errorf("%w: difficulty rules for chain %v, node is on chain %v", errAuditInconclusive, rules.ChainID, chainID.ToInt())
+	}
+	parent, err := fetchHeader(client, "eth_getBlockByHash", pending.ParentHash)
+	if err != nil {
+		return err
+	}
+	if want := rules.calcDifficulty(pending.Time, parent.Header); pending.Difficulty.Cmp(want) != 0 {
+		return fmt.Errorf("%w: pending block difficulty %v, calculated from parent %v", errWorkMismatch, pending.Difficulty, want)
+	}
+	return nil
+}
+
+// auditFailures counts the work packages that failed the integrity audit.
+var auditFailures struct {
+	sync.Mutex
+	count int
+}
+
+// audit runs the integrity audit on new work in the background. Failures are
+// logged and counted, and when stopping on failures the node is reported as
+// unhealthy, which stops mining on its work.
+func (p *getWorkPoller) audit(work Work, quit <-chan struct{}) {
+	err := auditWork(p.client, work)
+	switch {
+	case err == nil, errors.Is(err, errPendingChanged):
+		return
+	case errors.Is(err, errAuditInconclusive):
+		auditUnsupported.Do(func() {
+			log.Println("Unable to audit work from", p.client.name, "-", err)
+		})
+		return
+	case !errors.Is(err, errWorkMismatch):
+		logRPCError("Unable to audit work:", err)
+		return
+	}
+	auditFailures.Lock()
+	auditFailures.count++
+	count := auditFailures.count
+	auditFailures.Unlock()
+
+	log.Println("!!! WORK INTEGRITY AUDIT FAILED on", p.client.name, "for block", work.block(), "-", err, "- failures so far:", count)
+
+	if p.auditStop {
+		p.lock.Lock()
+		p.distrusted = "work integrity audit failed: " + err.Error()
+		reason := p.distrusted
+		p.lock.Unlock()
+
+		select {
+		case healthReports <- healthReport{source: p, reason: reason}:
+		case <-quit:
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package ethash
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// jwtSecretLength is the length of the shared secret nodes authenticate JWTs
+// with, as written by geth for its authenticated RPC.
+const jwtSecretLength = 32
+
+// rpcAuth is how calls to getWork endpoints authenticate. Nodes behind a proxy
+// take a basic auth login or a static bearer token, nodes with authenticated RPC
+// a JWT signed with a shared secret, issued anew for every request.
+type rpcAuth struct {
+	user      *url.Userinfo // Basic auth login, from the endpoint URL
+	bearer    string        // Static bearer token
+	jwtSecret []byte        // Secret to sign JWTs with
+	header    http.Header   // Custom headers sent with every request
+}
+
+// newRPCAuth creates the authentication for getWork endpoints from the options.
+// The JWT secret is read from a file holding it hex encoded.
+func newRPCAuth(options Options) (*rpcAuth, error) {
+	auth := &rpcAuth{bearer: options.BearerToken, header: make(http.Header)}
+	if options.JWTSecret != "" {
+		if auth.bearer != "" {
+			return nil, errors.New("a bearer token and a JWT secret can't be used together")
+		}
+		blob, err := os.ReadFile(options.JWTSecret)
+		if err != nil {
+			return nil, err
+		}
+		secret, err := hexutil.Decode("0x" + strings.TrimPrefix(strings.TrimSpace(string(blob)), "0x"))
+		if err != nil || len(secret) != jwtSecretLength {
+			return nil, fmt.Errorf("invalid JWT secret in %s, expected %d hex encoded bytes", options.JWTSecret, jwtSecretLength)
+		}
+		auth.jwtSecret = secret
+	}
+	for _, header := range options.Headers {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, errors.New("invalid header, expected \"Name: value\"")
+		}
+		auth.header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return auth, nil
+}
+
+// forURL returns the authentication for the endpoint at rawurl with the basic
+// auth login taken out of its URL, and the URL without it.
+func (a *rpcAuth) forURL(rawurl string) (*rpcAuth, string) {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.User == nil {
+		return a, rawurl
+	}
+	var auth rpcAuth
+	if a != nil {
+		auth = *a
+	}
+	auth.user, u.User = u.User, nil
+	return &auth, u.String()
+}
+
+// apply adds the authentication headers to a request.
+func (a *rpcAuth) apply(header http.Header) {
+	if a == nil {
+		return
+	}
+	for name, values := range a.header {
+		header[name] = values
+	}
+	switch {
+	case a.jwtSecret != nil:
+		header.Set("Authorization", "Bearer "+signJWT(a.jwtSecret, time.Now()))
+	case a.bearer != "":
+		header.Set("Authorization", "Bearer "+a.bearer)
+	case a.user != nil:
+		password, _ := a.user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(a.user.Username() + ":" + password))
+		header.Set("Authorization", "Basic "+credentials)
+	}
+}
+
+// signJWT issues an HS256 signed JWT with only an issued-at claim, which is what
+// nodes check to accept a request.
+func signJWT(secret []byte, now time.Time) string {
+	encode := base64.RawURLEncoding.EncodeToString
+
+	claims, _ := json.Marshal(struct {
+		IssuedAt int64 `json:"iat"`
+	}{now.Unix()})
+	token := encode([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + encode(claims)
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(token))
+	return token + "." + encode(mac.Sum(nil))
+}
@@ -0,0 +1,68 @@
+package ethash
+
+import (
+	"log"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// broadcastSource gets work from the wrapped source but submits every solution
+// to all configured nodes in parallel, so a found block propagates from several
+// points in the network and a single unhealthy node can't lose it. Pools only
+// know their own jobs, so they are only sent the solutions for their work.
+type broadcastSource struct {
+	WorkSource
+
+	sources []WorkSource
+	names   []string // Endpoint URLs for logging, without credentials
+}
+
+func newBroadcastSource(source WorkSource, sources []WorkSource, names []string) *broadcastSource {
+	return &broadcastSource{WorkSource: source, sources: sources, names: names}
+}
+
+func (b *broadcastSource) Submit(work Work, nonce types.BlockNonce, mixDigest common.Hash) bool {
+	type answer struct {
+		index    int
+		accepted bool
+	}
+	var targets []int
+	for i, source := range b.sources {
+		if poller, ok := source.(*getWorkPoller); (ok && !poller.pool) || source == work.source {
+			targets = append(targets, i)
+		}
+	}
+	if len(targets) == 0 {
+		log.Println("Solution discarded, no node to submit to.")
+		return false
+	}
+	answers := make(chan answer, len(targets))
+	for _, i := range targets {
+		go func(i int) {
+			answers <- answer{index: i, accepted: b.sources[i].Submit(work, nonce, mixDigest)}
+		}(i)
+	}
+	var (
+		accepted int
+		results  = make([]string, len(b.sources))
+	)
+	for range targets {
+		answer := <-answers
+		if answer.accepted {
+			accepted++
+			results[answer.index] = b.names[answer.index] + " accepted"
+		} else {
+			results[answer.index] = b.names[answer.index] + " rejected"
+		}
+	}
+	var report []string
+	for _, result := range results {
+		if result != "" {
+			report = append(report, result)
+		}
+	}
+	log.Println("Solution accepted by", accepted, "of", len(targets), "nodes:", strings.Join(report, ", "))
+	return accepted > 0
+}
@@ -429,6 +429,10 @@ type Ethash struct {
 	hashrate metrics.Meter // Meter tracking the average hashrate
 	remote   *remoteSealer
 
+	noncePrefix     uint64 // Fixed high bits of every nonce searched (pool extranonce)
+	noncePrefixBits uint   // Number of high nonce bits fixed by noncePrefix
+	continuous      bool   // Whether sealing goes on after a result, for mining shares
+
 	// The fields below are hooks for testing
 	shared    *Ethash       // Shared PoW verifier to avoid cache regeneration
 	fakeFail  uint64        // Block number which fails PoW check even in fake mode
@@ -634,6 +638,40 @@ func (ethash *Ethash) SetThreads(threads int) {
 	}
 }
 
+// SetNoncePrefix fixes the top bits of every nonce searched by subsequent Seal
+// calls to prefix, as required by pools handing out an extranonce to each
+// miner. A bits value of zero lets the miner search the whole nonce space.
+func (ethash *Ethash) SetNoncePrefix(prefix uint64, bits uint) {
+	ethash.lock.Lock()
+	defer ethash.lock.Unlock()
+
+	// If we're running a shared PoW, set the prefix on that instead
+	if ethash.shared != nil {
+		ethash.shared.SetNoncePrefix(prefix, bits)
+		return
+	}
+	if bits > 63 {
+		bits = 63
+	}
+	ethash.noncePrefix = prefix &^ (^uint64(0) >> bits)
+	ethash.noncePrefixBits = bits
+}
+
+// SetContinuous makes sealing go on searching after a result was found, so that
+// multiple results per block are streamed through the results channel until
+// sealing is stopped. This is what mining shares for a pool wants.
+func (ethash *Ethash) SetContinuous(continuous bool) {
+	ethash.lock.Lock()
+	defer ethash.lock.Unlock()
+
+	// If we're running a shared PoW, set the mode on that instead
+	if ethash.shared != nil {
+		ethash.shared.SetContinuous(continuous)
+		return
+	}
+	ethash.continuous = continuous
+}
+
 // Hashrate implements PoW, returning the measured rate of the search invocations
 // per second over the last minute.
 // Note the returned hashrate includes local hashrate, but also includes the total
@@ -0,0 +1,160 @@
+package ethash
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// ethProxyClient is the work source for pools speaking the eth-proxy dialect:
+// the eth_getWork and eth_submitWork calls of a node, sent as line delimited
+// JSON-RPC over a long lived TCP connection after an eth_submitLogin. New jobs
+// are pushed by the pool as unsolicited eth_getWork results.
+type ethProxyClient struct {
+	url      *url.URL
+	proxy    *url.URL // Proxy to connect through, nil for none
+	login    string
+	password string
+	worker   string
+
+	lock     sync.Mutex
+	conn     *stratumConn
+	lastHash string // Header hash of the last job delivered
+	getWork  chan<- Work
+	quit     <-chan struct{}
+}
+
+// newEthProxyClient creates an eth-proxy work source for an ethproxy+tcp:// URL.
+// The login is taken from the URL user info, defaulting to the wallet address
+// and worker name, and a "login.worker" login is split into the address and
+// worker name.
+func newEthProxyClient(rawurl string, login poolLogin, proxy *url.URL) (*ethProxyClient, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, errors.New("missing pool host")
+	}
+	c := &ethProxyClient{url: u, proxy: proxy, login: login.address, worker: login.worker}
+	if u.User != nil {
+		c.login, c.worker = u.User.Username(), ""
+		c.password, _ = u.User.Password()
+
+		if i := strings.Index(c.login, "."); i >= 0 {
+			c.login, c.worker = c.login[:i], c.login[i+1:]
+		}
+	}
+	if c.login == "" {
+		return nil, errors.New("missing pool login")
+	}
+	return c, nil
+}
+
+func (c *ethProxyClient) Run(getWork chan<- Work, quit <-chan struct{}) error {
+	c.lock.Lock()
+	c.getWork, c.quit, c.lastHash = getWork, quit, ""
+	c.lock.Unlock()
+
+	return keepConnected("Eth-proxy", c.connect, quit)
+}
+
+func (c *ethProxyClient) Probe() error {
+	return probeStratum(c.url, c.proxy)
+}
+
+// connect dials the pool, logs in and requests the current job.
+func (c *ethProxyClient) connect() (*stratumConn, error) {
+	conn, err := dialStratum(c.url, c.proxy, c.handle)
+	if err != nil {
+		return nil, err
+	}
+	params := []interface{}{c.login}
+	if c.password != "" {
+		params = append(params, c.password)
+	}
+	res, err := conn.call(&RpcInfo{Jsonrpc: "2.0", Method: "eth_submitLogin", Params: params, Worker: c.worker})
+	if err != nil {
+		conn.close()
+		return nil, err
+	}
+	var loggedIn bool
+	if json.Unmarshal(res.Result, &loggedIn); !loggedIn {
+		conn.close()
+		return nil, fmt.Errorf("login failed: %s", stratumError(res.Error))
+	}
+	c.lock.Lock()
+	c.conn = conn
+	c.lock.Unlock()
+
+	log.Println("Connected to eth-proxy pool", c.url.Host, "as", c.login)
+
+	go reportHashrate(poolHashrateInterval, func(params []interface{}) error {
+		return submitPoolHashrate(conn, c.worker, params)
+	}, conn.closed)
+
+	// The answer is handled like a pushed job so it can't overtake newer ones
+	if err := conn.send(&RpcInfo{Jsonrpc: "2.0", Method: "eth_getWork", Params: []interface{}{}, Worker: c.worker}); err != nil {
+		conn.close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// handle processes the work packages pushed by the pool.
+func (c *ethProxyClient) handle(msg *stratumMessage) {
+	if msg.Method != "" {
+		log.Println("Unsupported eth-proxy method:", msg.Method)
+		return
+	}
+	if err := parseRPCError(msg.Error); err != nil {
+		logRPCError("Eth-proxy pool error:", err)
+		return
+	}
+	var result []string
+	if err := json.Unmarshal(msg.Result, &result); err != nil {
+		log.Println("Invalid eth-proxy work:", string(msg.Result))
+		return
+	}
+	// Pools may leave out the block number, the seed hash tells the epoch
+	work, err := workFromPackage(result)
+	if err != nil {
+		log.Println("Refusing eth-proxy work:", err)
+		return
+	}
+	c.lock.Lock()
+	if work.Hash == c.lastHash {
+		c.lock.Unlock()
+		return
+	}
+	c.lastHash = work.Hash
+	getWork, quit := c.getWork, c.quit
+	c.lock.Unlock()
+
+	select {
+	case getWork <- work:
+	case <-quit:
+	}
+}
+
+func (c *ethProxyClient) Submit(work Work, nonce types.BlockNonce, mixDigest common.Hash) bool {
+	c.lock.Lock()
+	conn := c.conn
+	c.lock.Unlock()
+
+	if conn == nil {
+		log.Println("Share discarded, not connected to pool.")
+		return false
+	}
+	nonceHex, _ := nonce.MarshalText()
+	mixHex, _ := mixDigest.MarshalText()
+
+	return conn.submit("Share", &RpcInfo{Jsonrpc: "2.0", Method: "eth_submitWork", Params: []interface{}{string(nonceHex), work.Hash, string(mixHex)}, Worker: c.worker})
+}
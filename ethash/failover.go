@@ -0,0 +1,172 @@
+package ethash
+
+import (
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// failoverProbeInterval is how often endpoints of higher priority than the
+// active one are checked for whether they recovered.
+const failoverProbeInterval = 30 * time.Second
+
+// failoverSource mines on one of an ordered list of work sources at a time,
+// moving down the list when the active one fails or its node is unhealthy, and
+// returning to a higher priority one as soon as it's reachable again.
+type failoverSource struct {
+	sources []WorkSource
+	names   []string // Endpoint URLs for logging, without credentials
+}
+
+func newFailoverSource(sources []WorkSource, names []string) *failoverSource {
+	// Unhealthy nodes only pause mining if there's nothing to fail over to
+	if len(sources) > 1 {
+		for _, source := range sources {
+			if poller, ok := source.(*getWorkPoller); ok {
+				poller.failover = true
+			}
+		}
+	}
+	return &failoverSource{sources: sources, names: names}
+}
+
+func (f *failoverSource) Run(getWork chan<- Work, quit <-chan struct{}) error {
+	probeTimer := time.NewTicker(failoverProbeInterval)
+	defer probeTimer.Stop()
+
+	var (
+		active = 0
+		tried  = make(map[int]bool) // Endpoints that failed since the last work
+		retry  = backoff{min: retryMinDelay, max: retryMaxDelay}
+	)
+	for {
+		log.Println("Mining on endpoint", f.names[active])
+
+		var (
+			source = f.sources[active]
+			jobs   = make(chan Work)
+			stop   = make(chan struct{})
+			done   = make(chan error, 1)
+			probed = make(chan int, 1)
+
+			next    = -1
+			stopped = false
+		)
+		go func() { done <- source.Run(jobs, stop) }()
+
+		for next < 0 {
+			select {
+			case work := <-jobs:
+				work.source = source
+				if len(tried) > 0 {
+					tried = make(map[int]bool)
+				}
+				retry.reset()
+				select {
+				case getWork <- work:
+				case <-quit:
+				}
+
+			case err := <-done:
+				next, stopped = (active+1)%len(f.sources), true
+				log.Println("Endpoint", f.names[active], "failed:", err)
+
+				// Wait before another round over the failed endpoints
+				tried[active] = true
+				if tried[next] {
+					delay := retry.next()
+					log.Println("No endpoint available, retrying in", delay.Round(time.Second))
+					select {
+					case <-time.After(delay):
+					case <-quit:
+						return nil
+					}
+					tried = make(map[int]bool)
+				}
+
+			case <-probeTimer.C:
+				if active > 0 {
+					go f.probe(active, probed)
+				}
+
+			case i := <-probed:
+				if i < active {
+					next = i
+					log.Println("Endpoint", f.names[i], "is reachable again")
+				}
+
+			case <-quit:
+				close(stop)
+				<-done
+				return nil
+			}
+		}
+		close(stop)
+		if !stopped {
+			<-done
+		}
+		if next != active {
+			log.Println("Switching from endpoint", f.names[active], "to", f.names[next])
+		}
+		active = next
+	}
+}
+
+// probe checks the endpoints ranking above the active one, reporting the first
+// reachable one, if any, on probed.
+func (f *failoverSource) probe(active int, probed chan<- int) {
+	for i := 0; i < active; i++ {
+		if err := f.sources[i].Probe(); err == nil {
+			probed <- i
+			return
+		}
+	}
+	probed <- active
+}
+
+func (f *failoverSource) Submit(work Work, nonce types.BlockNonce, mixDigest common.Hash) bool {
+	if work.source == nil {
+		log.Println("Solution discarded, job has no endpoint.")
+		return false
+	}
+	return work.source.Submit(work, nonce, mixDigest)
+}
+
+func (f *failoverSource) Probe() error {
+	var err error
+	for _, source := range f.sources {
+		if err = source.Probe(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// redactURL strips any password from an endpoint URL so it can be logged.
+func redactURL(rawurl string) string {
+	// The proxy of an endpoint may carry credentials too
+	if rawurl, proxy := splitProxy(rawurl); proxy != "" {
+		return redactURL(rawurl) + "#proxy=" + redactURL(proxy)
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil || u.User == nil {
+		return rawurl
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
+}
+
+// redactURLs strips the passwords from a comma separated list of endpoints.
+func redactURLs(rawurls string) string {
+	endpoints := strings.Split(rawurls, ",")
+	for i, endpoint := range endpoints {
+		endpoints[i] = redactURL(strings.TrimSpace(endpoint))
+	}
+	return strings.Join(endpoints, ",")
+}
@@ -0,0 +1,102 @@
+package ethash
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+const (
+	nodeHashrateInterval = 5 * time.Second  // Nodes forget hash rates not refreshed within 10 seconds
+	poolHashrateInterval = 30 * time.Second // Time between two hash rate reports to a pool
+
+	rigIDFile = "rig-id" // File in the dataset directory keeping the rig id
+)
+
+// rigID identifies this rig to the nodes and pools its hash rate is reported to.
+var rigID common.Hash
+
+// loadRigID reads the rig id from the given file, creating a random one on the
+// first run so the rig keeps its identity across restarts.
+func loadRigID(path string) (common.Hash, error) {
+	blob, err := os.ReadFile(path)
+	if err == nil {
+		id, err := hexutil.Decode(strings.TrimSpace(string(blob)))
+		if err != nil || len(id) != common.HashLength {
+			return common.Hash{}, errors.New("invalid rig id in " + path)
+		}
+		return common.BytesToHash(id), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return common.Hash{}, err
+	}
+	var id common.Hash
+	if _, err := rand.Read(id[:]); err != nil {
+		return common.Hash{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return id, err
+	}
+	return id, os.WriteFile(path, []byte(id.Hex()+"\n"), 0644)
+}
+
+// reportHashrate hands the local hash rate with the rig id, as the parameters of
+// an eth_submitHashrate call, to report every interval until quit is closed.
+func reportHashrate(interval time.Duration, report func(params []interface{}) error, quit <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var failing bool
+	for {
+		select {
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+		rate := hexutil.Uint64(cpuHash.Hashrate())
+
+		// Only log the first of a series of failures, some endpoints never accept hash rates
+		if err := report([]interface{}{rate.String(), rigID.Hex()}); err != nil && !failing {
+			log.Println("Unable to report hash rate:", err)
+			failing = true
+		} else if err == nil {
+			failing = false
+		}
+	}
+}
+
+// submitHashrate reports the hash rate to a node.
+func (p *getWorkPoller) submitHashrate(params []interface{}) error {
+	var accepted bool
+	if err := callNode(p.client, "eth_submitHashrate", &accepted, params...); err != nil {
+		return err
+	}
+	if !accepted {
+		return errors.New("hash rate refused")
+	}
+	return nil
+}
+
+// submitPoolHashrate reports the hash rate to a pool over a stratum connection.
+func submitPoolHashrate(conn *stratumConn, worker string, params []interface{}) error {
+	res, err := conn.call(&RpcInfo{Jsonrpc: "2.0", Method: "eth_submitHashrate", Params: params, Worker: worker})
+	if err != nil {
+		return err
+	}
+	if err := parseRPCError(res.Error); err != nil {
+		return err
+	}
+	var accepted bool
+	if json.Unmarshal(res.Result, &accepted); !accepted {
+		return errors.New("hash rate refused")
+	}
+	return nil
+}
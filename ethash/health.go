@@ -0,0 +1,141 @@
+package ethash
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+const healthCheckInterval = 15 * time.Second // Time between two checks of a node's health
+
+// healthLimits are the conditions a node has to meet to be mined on.
+type healthLimits struct {
+	minPeers    int           // Minimum number of peers, 0 to not check
+	maxBlockAge time.Duration // Maximum age of the latest block, 0 to not check
+}
+
+// healthReport tells the mining loop whether the node behind a work source is
+// healthy, with the reason if it isn't.
+type healthReport struct {
+	source WorkSource
+	reason string
+}
+
+// healthReports carries the changes in node health to the mining loop.
+var healthReports = make(chan healthReport)
+
+// monitorHealth checks the node's health until quit is closed, reporting every
+// change to the mining loop. The first check is always reported, as the mining
+// loop may still hold the node's state from a previous run, and checked is
+// closed once it was. When failing over, an unhealthy node is given up on by
+// reporting it on unhealthy instead, ending the monitoring.
+func (p *getWorkPoller) monitorHealth(checked chan<- struct{}, unhealthy chan<- string, quit <-chan struct{}) {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	var (
+		last     string
+		reported bool
+	)
+	for {
+		reason := p.checkHealth()
+		if !reported || reason != last {
+			select {
+			case healthReports <- healthReport{source: p, reason: reason}:
+				last = reason
+			case <-quit:
+				return
+			}
+		}
+		if reason != "" && p.failover {
+			unhealthy <- reason
+			return
+		}
+		if !reported {
+			close(checked)
+			reported = true
+		}
+		select {
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+	}
+}
+
+// checkHealth returns why the node shouldn't be mined on, or an empty string if
+// it's healthy. Nodes that failed the work integrity audit stay unhealthy.
+func (p *getWorkPoller) checkHealth() string {
+	p.lock.Lock()
+	reason := p.distrusted
+	p.lock.Unlock()
+
+	if reason != "" {
+		return reason
+	}
+	return checkHealth(p.client, p.health)
+}
+
+// checkHealth returns why the node shouldn't be mined on, or an empty string if
+// it's healthy. Checks the node can't answer are skipped, an unreachable node is
+// dealt with by the work source.
+func checkHealth(client *rpcClient, limits healthLimits) string {
+	var syncing json.RawMessage
+	if callNode(client, "eth_syncing", &syncing) == nil && string(syncing) != "false" {
+		var progress struct {
+			CurrentBlock hexutil.Uint64 `json:"currentBlock"`
+			HighestBlock hexutil.Uint64 `json:"highestBlock"`
+		}
+		json.Unmarshal(syncing, &progress)
+		return fmt.Sprintf("node is syncing (block %d of %d)", progress.CurrentBlock, progress.HighestBlock)
+	}
+	if limits.minPeers > 0 {
+		var peers hexutil.Uint64
+		if callNode(client, "net_peerCount", &peers) == nil && int(peers) < limits.minPeers {
+			return "node has " + strconv.Itoa(int(peers)) + " peers"
+		}
+	}
+	if limits.maxBlockAge > 0 {
+		var block struct {
+			Number    hexutil.Uint64 `json:"number"`
+			Timestamp hexutil.Uint64 `json:"timestamp"`
+		}
+		if callNode(client, "eth_getBlockByNumber", &block, "latest", false) == nil && block.Timestamp > 0 {
+			if age := time.Since(time.Unix(int64(block.Timestamp), 0)); age > limits.maxBlockAge {
+				return fmt.Sprintf("latest block %d is %v old", block.Number, age.Round(time.Second))
+			}
+		}
+	}
+	return ""
+}
+
+// callNode calls a method of the node and decodes its result into result.
+func callNode(transport rpcTransport, method string, result interface{}, params ...interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	body, err := transport.call(&RpcInfo{Jsonrpc: "2.0", Method: method, Params: params, Id: 1})
+	if err != nil {
+		return err
+	}
+	var res struct {
+		Result json.RawMessage `json:"result"`
+		Error  json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return errInvalidResponse
+	}
+	if err := parseRPCError(res.Error); err != nil {
+		return err
+	}
+	if len(res.Result) == 0 || string(res.Result) == "null" {
+		return errInvalidResponse
+	}
+	if err := json.Unmarshal(res.Result, result); err != nil {
+		return errInvalidResponse
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package ethash
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const ipcDialTimeout = 10 * time.Second // Timeout for connecting to the node's IPC socket
+
+// ipcCodec streams JSON-RPC messages over the node's unix domain socket, the
+// same framing geth uses on its IPC endpoint.
+type ipcCodec struct {
+	conn    net.Conn
+	decoder *json.Decoder
+}
+
+// dialIPC connects to the IPC socket of a node.
+func dialIPC(path string) (rpcCodec, error) {
+	conn, err := net.DialTimeout("unix", path, ipcDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &ipcCodec{conn: conn, decoder: json.NewDecoder(conn)}, nil
+}
+
+// isIPCPath reports whether the miner was pointed at an IPC socket rather than
+// a URL, either by an ipc:// prefix, the .ipc extension or the file type.
+func isIPCPath(rawurl string) bool {
+	if strings.HasPrefix(rawurl, "ipc://") || strings.HasSuffix(rawurl, ".ipc") {
+		return true
+	}
+	if strings.Contains(rawurl, "://") {
+		return false
+	}
+	info, err := os.Stat(rawurl)
+	return err == nil && info.Mode()&os.ModeSocket != 0
+}
+
+func (c *ipcCodec) readMessage() ([]byte, error) {
+	var blob json.RawMessage
+	if err := c.decoder.Decode(&blob); err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+func (c *ipcCodec) writeMessage(blob []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(rpcRequestTimeout))
+	_, err := c.conn.Write(blob)
+	return err
+}
+
+func (c *ipcCodec) close() error {
+	return c.conn.Close()
+}
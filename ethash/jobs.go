@@ -0,0 +1,55 @@
+package ethash
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// maxRegisteredJobs is the number of most recent jobs solutions are still
+// accepted from the miner threads for.
+const maxRegisteredJobs = 64
+
+// jobRegistry remembers the jobs handed to the miner threads by seal hash, so
+// every solution is submitted against the work it was found for, even if a
+// newer job replaced it in the meantime.
+type jobRegistry struct {
+	jobs    map[common.Hash]Work
+	order   []common.Hash // Seal hashes in the order the jobs were registered
+	current common.Hash   // Seal hash of the job being mined
+
+	stale   int // Solutions found for a job that was already replaced
+	unknown int // Solutions found for a job no longer registered
+}
+
+func newJobRegistry() *jobRegistry {
+	return &jobRegistry{jobs: make(map[common.Hash]Work)}
+}
+
+// add registers a job and makes it the one being mined.
+func (r *jobRegistry) add(work Work) {
+	hash := common.HexToHash(work.Hash)
+	if _, ok := r.jobs[hash]; !ok {
+		r.order = append(r.order, hash)
+	}
+	r.jobs[hash] = work
+	r.current = hash
+
+	if len(r.order) > maxRegisteredJobs {
+		delete(r.jobs, r.order[0])
+		r.order = r.order[1:]
+	}
+}
+
+// lookup returns the job a solution with the given seal hash was found for,
+// and whether it's still the one being mined.
+func (r *jobRegistry) lookup(hash common.Hash) (work Work, current bool, ok bool) {
+	work, ok = r.jobs[hash]
+	if !ok {
+		r.unknown++
+		return Work{}, false, false
+	}
+	if hash != r.current {
+		r.stale++
+		return work, false, true
+	}
+	return work, true, true
+}
@@ -0,0 +1,68 @@
+package ethash
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultURLTemplate is the getWork endpoint URL of open-ethereum-pool and its
+// derivatives, which take the login from the request path.
+const DefaultURLTemplate = "{url}/{address}/{worker}"
+
+// poolLogin is how the wallet address and worker name are sent to pools serving
+// the eth_getWork API. Pools tell them from the request URL, expanded from a
+// template with {url}, {address} and {worker} placeholders, or from a header.
+type poolLogin struct {
+	address  string
+	worker   string
+	template string // URL template, DefaultURLTemplate if empty
+	header   string // Header carrying the login as "Name: value template", if any
+}
+
+// expand fills in the placeholders of a template.
+func (l poolLogin) expand(template string, rawurl string) string {
+	worker := l.worker
+	if worker == "" {
+		worker = "1"
+	}
+	return strings.NewReplacer(
+		"{url}", strings.TrimSuffix(rawurl, "/"),
+		"{address}", url.PathEscape(l.address),
+		"{worker}", url.PathEscape(worker),
+	).Replace(template)
+}
+
+// url returns the URL to send calls for the endpoint at rawurl to. Without a
+// wallet address the endpoint is a node and the URL is used as is.
+func (l poolLogin) url(rawurl string) string {
+	if l.address == "" {
+		return rawurl
+	}
+	template := l.template
+	if template == "" {
+		template = DefaultURLTemplate
+	}
+	return l.expand(template, rawurl)
+}
+
+// headers returns the login header to send with every request, if any.
+func (l poolLogin) headers() http.Header {
+	header := make(http.Header)
+	if l.address == "" || l.header == "" {
+		return header
+	}
+	if name, value, ok := strings.Cut(l.header, ":"); ok {
+		header.Set(strings.TrimSpace(name), strings.TrimSpace(l.expand(value, "")))
+	}
+	return header
+}
+
+// stratumLogin returns the login for stratum pools, which expect the worker name
+// appended to the wallet address.
+func (l poolLogin) stratumLogin() string {
+	if l.worker == "" || l.address == "" {
+		return l.address
+	}
+	return l.address + "." + l.worker
+}
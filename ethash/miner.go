@@ -3,18 +3,21 @@ package ethash
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"ethashcpu/util"
+	"fmt"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
-	"io"
 	"log"
 	"math/big"
-	"net/http"
 	"os"
 	"os/user"
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -24,27 +27,73 @@ var globalThreads int
 var walletAddress string
 
 type RpcReback struct {
-	Jsonrpc string   `json:"jsonrpc"`
-	Result  []string `json:"result"`
-	Id      int      `json:"id"`
+	Jsonrpc string          `json:"jsonrpc"`
+	Result  []string        `json:"result"`
+	Error   json.RawMessage `json:"error"`
+	Id      int             `json:"id"`
 }
 
 type WorkResult struct {
-	Jsonrpc string `json:"jsonrpc"`
-	Result  bool   `json:"result"`
-	Id      int    `json:"id"`
+	Jsonrpc string          `json:"jsonrpc"`
+	Result  bool            `json:"result"`
+	Error   json.RawMessage `json:"error"`
+	Id      int             `json:"id"`
 }
 
 type RpcInfo struct {
-	Jsonrpc string   `json:"jsonrpc"`
-	Method  string   `json:"method"`
-	Params  []string `json:"params"`
-	Id      int      `json:"id"`
+	Jsonrpc string        `json:"jsonrpc,omitempty"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+	Id      int           `json:"id"`
+	Worker  string        `json:"worker,omitempty"` // eth-proxy worker name
+	Job     string        `json:"job,omitempty"`    // Work source plugin job id
 }
 
 type Work struct {
 	Header *types.Header
 	Hash   string
+	Job    string // Pool assigned job id, empty for getWork endpoints
+
+	source WorkSource // Endpoint the job came from, solutions go back there
+	exact  bool       // Whether Header.Number is the actual block, not the first one of its epoch
+
+	noncePrefix uint64 // Top nonce bits fixed by the pool's extranonce
+	nonceBits   uint   // Number of fixed nonce bits, 0 to search the whole nonce space
+}
+
+// block describes the block a job is for, by number if known, or else by its
+// ethash epoch.
+func (w Work) block() string {
+	if w.exact {
+		return w.Header.Number.String()
+	}
+	return fmt.Sprintf("? (epoch %d)", w.Header.Number.Uint64()/epochLength)
+}
+
+// errSeedMismatch is returned for work whose seed hash doesn't belong to its
+// block, as handed out by nodes of chains using a different ethash variant.
+var errSeedMismatch = errors.New("seed hash does not match the ethash epoch")
+
+// statusInterval is the time between two logs of the mining status.
+const statusInterval = time.Minute
+
+// maxEndpointFailures is the number of consecutive failures to fetch work or
+// connect after which a work source gives up on its endpoint.
+const maxEndpointFailures = 3
+
+// WorkSource delivers mining jobs to StartMiner and accepts the solutions found
+// for them.
+type WorkSource interface {
+	// Run delivers new jobs on getWork until quit is closed, or returns an error
+	// once the endpoint keeps failing.
+	Run(getWork chan<- Work, quit <-chan struct{}) error
+
+	// Submit hands a solution for work back to the source, returning whether it
+	// was accepted.
+	Submit(work Work, nonce types.BlockNonce, mixDigest common.Hash) bool
+
+	// Probe checks whether the endpoint is reachable without running it.
+	Probe() error
 }
 
 func InitConfig(currConfig *Config) {
@@ -70,24 +119,216 @@ func InitConfig(currConfig *Config) {
 	}
 }
 
-func Start(url string, threads string, address string) {
+// Options are the optional miner settings besides the RPC URL, thread count and
+// wallet address.
+type Options struct {
+	NotifyAddr string // Listen address for work packages pushed by a node's --miner.notify
+	Race       bool   // Fetch work from all endpoints at once instead of failing over
+	Broadcast  bool   // Submit solutions to all endpoints instead of only the one the job came from
+
+	MinPeers    int           // Peers a node needs to be mined on, 0 to not check
+	MaxBlockAge time.Duration // Age of a node's latest block after which mining pauses, 0 to not check
+
+	Audit        bool   // Check work from nodes against their pending block
+	AuditStop    bool   // Stop mining on a node whose work failed the audit
+	AuditGenesis string // Genesis file with the difficulty rules of the chain to audit, if not the Ethereum mainnet
+
+	Worker      string // Worker name reported to pools
+	URLTemplate string // Template of getWork pool URLs, DefaultURLTemplate if empty
+	LoginHeader string // Header carrying the getWork pool login as "Name: value template", if any
+
+	Record string // File to record the calls to getWork endpoints to, if any
+
+	BearerToken string   // Static bearer token sent to getWork endpoints
+	JWTSecret   string   // File with the hex encoded secret to sign JWTs for getWork endpoints with
+	Headers     []string // Custom headers sent to getWork endpoints, as "Name: value"
+
+	CACert     string   // PEM file with the CA certificates to trust instead of the system ones
+	ClientCert string   // PEM file with the client certificate for mutual TLS
+	ClientKey  string   // PEM file with the key of the client certificate
+	Pins       []string // Accepted public keys of server certificates, as sha256//<base64 hash>
+
+	Proxy string // Proxy for endpoints without their own, "direct" to ignore the environment
+}
+
+// Start begins mining on the comma separated list of endpoints in url, in order
+// of priority, or on all of them at once when racing.
+func Start(url string, threads string, address string, options Options) {
 	globalThreads, _ = strconv.Atoi(threads)
 	rpcUrl = url
 	walletAddress = address
 
 	if walletAddress == "" {
-		log.Println("Starting CPU Ethash-B3 mining. Connected RPC URL:", rpcUrl)
+		log.Println("Starting CPU Ethash-B3 mining. Connected RPC URL:", redactURLs(rpcUrl))
+	} else {
+		log.Println("Starting CPU Ethash-B3 mining. Connected RPC URL:", redactURLs(rpcUrl), "with address:", walletAddress)
+	}
+	if walletAddress != "" && !util.IsValidChecksumAddress(walletAddress) {
+		log.Println("Invalid wallet address:", walletAddress, "- expected 0x followed by 40 hex digits, with a valid EIP-55 checksum if mixed case")
+		os.Exit(1)
+	}
+	if options.LoginHeader != "" && !strings.Contains(options.LoginHeader, ":") {
+		log.Println("Invalid login header:", options.LoginHeader, "- expected \"Name: value\"")
+		os.Exit(1)
+	}
+
+	auth, err := newRPCAuth(options)
+	if err != nil {
+		log.Println("Invalid RPC authentication:", err)
+		os.Exit(1)
+	}
+	if config, err := newTLSConfig(options); err != nil {
+		log.Println("Invalid TLS configuration:", err)
+		os.Exit(1)
+	} else if config != nil {
+		configureTLS(config)
+	}
+	if options.AuditGenesis != "" {
+		rules, err := loadDifficultyRules(options.AuditGenesis)
+		if err != nil {
+			log.Println("Invalid audit genesis file:", err)
+			os.Exit(1)
+		}
+		auditRules = rules
+	}
+	if options.Record != "" {
+		recorder, err := openRecorder(options.Record)
+		if err != nil {
+			log.Println("Unable to open recording:", err)
+			os.Exit(1)
+		}
+		trafficRecorder = recorder
+	}
+	var (
+		sources  []WorkSource
+		names    []string
+		receiver *workReceiver
+	)
+	if options.NotifyAddr != "" {
+		receiver = newWorkReceiver()
+	}
+	for _, endpoint := range strings.Split(rpcUrl, ",") {
+		endpoint = strings.TrimSpace(endpoint)
+		if endpoint == "" {
+			continue
+		}
+		var pushed chan Work
+		if receiver != nil {
+			pushed = make(chan Work)
+		}
+		source, err := newWorkSource(endpoint, walletAddress, pushed, auth, options)
+		if err != nil {
+			log.Println("Invalid work source", redactURL(endpoint), "-", err)
+			os.Exit(1)
+		}
+		sources = append(sources, source)
+		names = append(names, redactURL(endpoint))
+
+		if _, ok := source.(*getWorkPoller); ok && receiver != nil {
+			receiver.add(len(sources), names[len(names)-1], pushed)
+		}
+	}
+	if receiver != nil {
+		if err := receiver.listen(options.NotifyAddr); err != nil {
+			log.Println("Unable to listen for work notifications:", err)
+			os.Exit(1)
+		}
+	}
+	if len(sources) == 0 {
+		log.Println("No work source configured.")
+		os.Exit(1)
+	}
+	var config Config
+	InitConfig(&config)
+
+	id, err := loadRigID(filepath.Join(config.DatasetDir, rigIDFile))
+	if err != nil {
+		log.Println("Unable to persist rig id:", err)
+	}
+	rigID = id
+	log.Println("Rig id:", rigID.Hex())
+
+	var clients []*rpcClient
+	for _, source := range sources {
+		if poller, ok := source.(*getWorkPoller); ok {
+			clients = append(clients, poller.client)
+		}
+	}
+	if len(clients) > 0 {
+		outbox, err := openOutbox(filepath.Join(config.DatasetDir, outboxFile))
+		if err != nil {
+			log.Println("Unable to open solution outbox:", err)
+			os.Exit(1)
+		}
+		pendingSolutions = outbox
+		go outbox.run(clients)
+	}
+	var source WorkSource
+	if options.Race {
+		source = newRaceSource(sources, names)
 	} else {
-		log.Println("Starting CPU Ethash-B3 mining. Connected RPC URL:", rpcUrl, "with address:", walletAddress)
+		source = newFailoverSource(sources, names)
+	}
+	if options.Broadcast {
+		source = newBroadcastSource(source, sources, names)
 	}
 
 	getWork := make(chan Work)
-	submitWork := make(chan *types.Block)
+	submitWork := make(chan *SealResult)
 
-	StartMiner(getWork, submitWork)
+	StartMiner(source, getWork, submitWork)
 }
 
-func StartMiner(getWork chan Work, submitWork chan *types.Block) {
+// newWorkSource picks the protocol to fetch work with from the URL scheme:
+//
+//	stratum+tcp://, stratum2+tcp://  EthereumStratum/1.0.0
+//	ethproxy+tcp://, stratum1+tcp:// eth-proxy
+//	+ssl:// or +tls:// instead       the same over TLS
+//	ws://, wss://                    eth_getWork over WebSocket, following new heads
+//	ipc://, *.ipc or a socket path   eth_getWork over IPC, following new heads
+//	replay:file[#endpoint]           eth_getWork played back from a recording
+//	exec:command args...             work source plugin over stdin and stdout
+//	anything else                    eth_getWork over HTTP
+//
+// Network endpoints are reached through the proxy given with a #proxy= suffix,
+// the -proxy flag or the environment. getWork endpoints additionally accept the
+// work on pushed, if any, as sent by their node with --miner.notify, and have their
+// node's health monitored.
+func newWorkSource(rawurl string, address string, pushed chan Work, auth *rpcAuth, options Options) (WorkSource, error) {
+	login := poolLogin{address: address, worker: options.Worker, template: options.URLTemplate, header: options.LoginHeader}
+
+	if strings.HasPrefix(rawurl, "exec:") {
+		return newPluginSource(rawurl)
+	}
+	rawurl, proxy := splitProxy(rawurl)
+	proxyURL, err := resolveProxy(rawurl, proxy, options.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	switch scheme, _, _ := strings.Cut(rawurl, "://"); scheme {
+	case "stratum+tcp", "stratum2+tcp", "stratum+ssl", "stratum2+ssl", "stratum+tls", "stratum2+tls":
+		return newStratumClient(rawurl, login, proxyURL)
+	case "ethproxy+tcp", "stratum1+tcp", "ethproxy+ssl", "stratum1+ssl", "ethproxy+tls", "stratum1+tls":
+		return newEthProxyClient(rawurl, login, proxyURL)
+	}
+	client, err := newRPCClient(rawurl, login, auth, proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	var poller *getWorkPoller
+	if pushed == nil {
+		poller = newGetWorkPoller(client, 5*time.Second, nil)
+	} else {
+		// With work pushed by the node, polling is only a heartbeat
+		poller = newGetWorkPoller(client, 30*time.Second, pushed)
+	}
+	poller.health = healthLimits{minPeers: options.MinPeers, maxBlockAge: options.MaxBlockAge}
+	poller.pool = address != ""
+	poller.auditing, poller.auditStop = options.Audit || options.AuditStop, options.AuditStop
+	return poller, nil
+}
+
+func StartMiner(source WorkSource, getWork chan Work, submitWork chan *SealResult) {
 	newConfig := Config{
 		CacheDir:         "ethash",
 		CachesInMem:      2,
@@ -99,6 +340,7 @@ func StartMiner(getWork chan Work, submitWork chan *types.Block) {
 	}
 	InitConfig(&newConfig)
 	cpuHash = New(newConfig, nil, false, globalThreads)
+	cpuHash.SetContinuous(true) // Pools want every share, not just the first one of a job
 	defer func(cpuHash *Ethash) {
 		err := cpuHash.Close()
 		if err != nil {
@@ -106,154 +348,383 @@ func StartMiner(getWork chan Work, submitWork chan *types.Block) {
 		}
 	}(cpuHash)
 
-	stop := make(chan int)
-	currentBlock := Work{Header: &types.Header{Number: new(big.Int)}}
-	getWorkTimer := time.NewTicker(5 * time.Second)
-	first := true
-
 	go func() {
+		statusTimer := time.NewTicker(statusInterval)
+		defer statusTimer.Stop()
+
+		var (
+			currentBlock Work
+			jobs         = newJobRegistry()
+			unhealthy    = make(map[WorkSource]string) // Reasons the nodes behind sources are unhealthy
+			paused       bool
+			stop         chan int
+		)
+		// seal aborts any running search and starts hashing on the given work
+		seal := func(work Work) {
+			if stop != nil {
+				close(stop)
+			}
+			stop = make(chan int)
+			cpuHash.SetNoncePrefix(work.noncePrefix, work.nonceBits)
+			if err := cpuHash.Seal(nil, types.NewBlockWithHeader(work.Header), submitWork, stop, common.HexToHash(work.Hash)); err != nil {
+				log.Println("Failed to seal block:", err)
+			}
+		}
+		// update pauses hashing while the node behind the current work is
+		// unhealthy and resumes once it recovered. The DAG stays loaded while
+		// paused, so hashing resumes right away.
+		update := func() {
+			reason := unhealthy[currentBlock.source]
+			switch {
+			case reason != "" && !paused:
+				log.Println("Mining paused:", reason)
+				paused = true
+				if stop != nil {
+					close(stop)
+					stop = nil
+				}
+			case reason == "" && paused:
+				log.Println("Mining resumed.")
+				paused = false
+				seal(currentBlock)
+			}
+		}
 		for {
 			select {
 			case work := <-getWork:
-				if work.Header.Number.Cmp(currentBlock.Header.Number) != 0 {
-					log.Println("New Job:", work.Header.Number, "| Difficulty:", work.Header.Difficulty)
-					currentBlock = work
+				if currentBlock.Header == nil || work.block() != currentBlock.block() {
+					log.Println("New Job:", work.block(), "| Difficulty:", work.Header.Difficulty)
 				}
-
-				if !first {
-					stop <- 1
+				// Work is for the block on top of the chain head
+				if pendingSolutions != nil && work.exact && work.Header.Number.Sign() > 0 {
+					pendingSolutions.setHead(work.Header.Number.Uint64() - 1)
+				}
+				currentBlock = work
+				jobs.add(work)
+				if paused || unhealthy[work.source] != "" {
+					update()
+				} else {
+					seal(work)
 				}
 
-				first = false
-				err := cpuHash.Seal(nil, types.NewBlockWithHeader(work.Header), submitWork, stop, common.HexToHash(work.Hash))
-
-				if err != nil {
-					log.Fatalf("failed to seal block: %v", err)
-					return
+			case report := <-healthReports:
+				if report.reason == "" {
+					delete(unhealthy, report.source)
+				} else {
+					unhealthy[report.source] = report.reason
 				}
-			case block := <-submitWork:
-				currentBlock.Header.Nonce = types.EncodeNonce(block.Nonce())
-				currentBlock.Header.MixDigest = block.MixDigest()
-				nonce, _ := currentBlock.Header.Nonce.MarshalText()
-				mix, _ := currentBlock.Header.MixDigest.MarshalText()
-				SubmitWork(string(nonce), currentBlock.Hash, string(mix), *currentBlock.Header)
-
-				first = true
-				foundWork := false
-
-				// Re-try connection for 10 seconds if unable to get work
-				for i := 0; i < 10; i++ {
-					header, hash := GetWorkHead()
-
-					if header != nil {
-						go func() {
-							getWork <- Work{Header: header, Hash: hash}
-						}()
-						foundWork = true
-						break
-					}
-
-					time.Sleep(1 * time.Second)
+				if currentBlock.Header != nil && report.source == currentBlock.source {
+					update()
 				}
 
-				if !foundWork {
-					log.Println("Unable to find job. Exiting.")
-					os.Exit(1)
+			case <-statusTimer.C:
+				switch {
+				case currentBlock.Header == nil:
+					log.Println("Status: waiting for work")
+				case paused:
+					log.Println("Status: paused,", unhealthy[currentBlock.source])
+				default:
+					log.Println("Status: mining block", currentBlock.block(), "| Hashrate:", fmt.Sprintf("%.0f H/s", cpuHash.Hashrate()))
 				}
 
-			case <-getWorkTimer.C:
-				header, hash := GetWorkHead()
-				if header == nil {
+			case result := <-submitWork:
+				work, current, ok := jobs.lookup(result.SealHash)
+				if !ok {
+					log.Println("Solution discarded, job", result.SealHash.Hex(), "is no longer known. Unknown so far:", jobs.unknown)
 					continue
 				}
-
-				if hash == currentBlock.Hash {
-					continue
+				// Sealing goes on after a result until the source hands out a
+				// new job, submit in the background as sources may need to
+				// deliver work before answering.
+				if !current {
+					log.Println("Solution for block", work.block(), "found after its job was replaced. Stale on arrival so far:", jobs.stale)
 				}
-
-				go func() {
-					getWork <- Work{Header: header, Hash: hash}
-				}()
+				block := result.Block
+				go source.Submit(work, types.EncodeNonce(block.Nonce()), block.MixDigest())
 			}
 		}
 	}()
 
-	header, hash := GetWorkHead()
-	if header == nil {
-		return
+	go func() {
+		err := source.Run(getWork, make(chan struct{}))
+		log.Println("Work source stopped:", err)
+	}()
+}
+
+// getWorkPoller is the work source for nodes and pools serving the eth_getWork
+// JSON-RPC API, polling for new work every few seconds. Over transports that
+// support subscriptions, work is also refetched as soon as a new head arrives.
+type getWorkPoller struct {
+	client   *rpcClient
+	interval time.Duration // Time between two polls for work
+	pushed   chan Work     // Work pushed by a node with --miner.notify
+	refresh  chan struct{} // Requests to refetch work right away
+	health   healthLimits  // Conditions for the node to be mined on
+	failover bool          // Whether to give up on the node while it's unhealthy, as there are other endpoints
+
+	pool      bool // Whether the endpoint is a getWork pool, logged in to with the wallet address
+	auditing  bool // Whether new work is audited against the pending block
+	auditStop bool // Whether to stop mining on the node when an audit fails
+
+	lock       sync.Mutex
+	distrusted string // Why the node's work is no longer trusted, if so
+}
+
+func newGetWorkPoller(client *rpcClient, interval time.Duration, pushed chan Work) *getWorkPoller {
+	return &getWorkPoller{
+		client:   client,
+		interval: interval,
+		pushed:   pushed,
+		refresh:  make(chan struct{}, 1),
 	}
+}
 
-	getWork <- Work{Header: header, Hash: hash}
+func (p *getWorkPoller) Run(getWork chan<- Work, quit <-chan struct{}) error {
+	if sub, ok := p.client.transport.(subscriber); ok {
+		go p.followHeads(sub, quit)
+	}
+	var (
+		checked   = make(chan struct{})
+		unhealthy = make(chan string, 1)
+	)
+	go p.monitorHealth(checked, unhealthy, quit)
+	go reportHashrate(nodeHashrateInterval, p.submitHashrate, quit)
+
+	// The mining loop knows the node's health before it gets its work
+	select {
+	case <-checked:
+	case reason := <-unhealthy:
+		return errors.New(reason)
+	case <-quit:
+		return nil
+	}
+
+	var (
+		lastHash string
+		failures int
+		retry    = backoff{min: retryMinDelay, max: p.interval}
+	)
+	work, err := p.poll()
+	for {
+		delay := p.interval
+		if errors.Is(err, errSeedMismatch) {
+			log.Println("Refusing to mine on", p.client.name, "-", err)
+			return err
+		}
+		if err != nil {
+			logRPCError("Unable to get work:", err)
+			if failures++; failures >= maxEndpointFailures {
+				return err
+			}
+			delay = retry.next()
+		} else if failures = 0; work.Hash != lastHash {
+			retry.reset()
+			lastHash = work.Hash
+			if p.auditing {
+				go p.audit(work, quit)
+			}
+			select {
+			case getWork <- work:
+			case <-quit:
+				return nil
+			}
+		}
+		select {
+		case <-time.After(delay):
+			work, err = p.poll()
+		case <-p.refresh:
+			work, err = p.poll()
+		case work = <-p.pushed:
+			err = nil
+		case reason := <-unhealthy:
+			return errors.New(reason)
+		case <-quit:
+			return nil
+		}
+	}
 }
 
-func SubmitWork(nonce string, blockHash string, mixHash string, currentBlock types.Header) {
-	getWorkInfo := RpcInfo{Method: "eth_submitWork", Params: []string{nonce, blockHash, mixHash}, Id: 1, Jsonrpc: "2.0"}
-	getWorkInfoBuffs, _ := json.Marshal(getWorkInfo)
+// poll fetches the current work package from the node.
+func (p *getWorkPoller) poll() (Work, error) {
+	return fetchWork(p.client)
+}
 
-	req := new(http.Request)
+// followHeads keeps a newHeads subscription open, nudging the poller on every
+// new head. Polling carries on while the subscription is down.
+func (p *getWorkPoller) followHeads(sub subscriber, quit <-chan struct{}) {
+	retry := backoff{min: retryMinDelay, max: retryMaxDelay}
+	for {
+		dropped, err := sub.subscribe([]interface{}{"newHeads"}, func(json.RawMessage) {
+			select {
+			case p.refresh <- struct{}{}:
+			default:
+			}
+		})
+		if err != nil {
+			log.Println("Unable to subscribe to new heads:", err)
+		} else {
+			log.Println("Subscribed to new heads.")
+			retry.reset()
+			select {
+			case <-dropped:
+				log.Println("New heads subscription lost, falling back to polling.")
+			case <-quit:
+				return
+			}
+		}
+		select {
+		case <-time.After(retry.next()):
+		case <-quit:
+			return
+		}
+	}
+}
 
-	if walletAddress == "" {
-		req, _ = http.NewRequest("POST", rpcUrl, bytes.NewBuffer(getWorkInfoBuffs))
-	} else {
-		req, _ = http.NewRequest("POST", rpcUrl+"/"+walletAddress+"/1", bytes.NewBuffer(getWorkInfoBuffs))
+func (p *getWorkPoller) Submit(work Work, nonce types.BlockNonce, mixDigest common.Hash) bool {
+	nonceHex, _ := nonce.MarshalText()
+	mixHex, _ := mixDigest.MarshalText()
+	accepted, err := SubmitWork(p.client, string(nonceHex), work.Hash, string(mixHex), *work.Header)
+	var callErr *rpcCallError
+	switch {
+	case errors.As(err, &callErr):
+		logRPCError("Job rejected:", err)
+	case err != nil:
+		logRPCError("Solution submission failed:", err)
+
+		// No node answered, keep the solution for retrying
+		if pendingSolutions != nil {
+			solution := pendingSolution{Nonce: nonce, Hash: work.Hash, MixDigest: mixDigest}
+			if work.exact {
+				solution.Number = work.Header.Number.Uint64()
+			}
+			pendingSolutions.add(solution)
+			log.Println("Solution queued for retry.")
+		}
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	// A solution usually means a new block, fetch its work right away
+	select {
+	case p.refresh <- struct{}{}:
+	default:
+	}
+	return accepted
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// Probe fetches work from the node. When failing over, the node also has to be
+// healthy, so mining doesn't return to it only to leave again.
+func (p *getWorkPoller) Probe() error {
+	if _, err := p.poll(); err != nil {
+		return err
+	}
+	if p.failover {
+		if reason := p.checkHealth(); reason != "" {
+			return errors.New(reason)
+		}
+	}
+	return nil
+}
+
+func SubmitWork(transport rpcTransport, nonce string, blockHash string, mixHash string, currentBlock types.Header) (bool, error) {
+	getWorkInfo := RpcInfo{Method: "eth_submitWork", Params: []interface{}{nonce, blockHash, mixHash}, Id: 1, Jsonrpc: "2.0"}
+
+	body, err := transport.call(&getWorkInfo)
 	if err != nil {
-		log.Println(err)
+		return false, err
 	}
-	defer resp.Body.Close()
-	body, _ := io.ReadAll(resp.Body)
 
 	workResult := new(WorkResult)
 
-	json.Unmarshal(body, workResult)
+	if err := json.Unmarshal(body, workResult); err != nil {
+		return false, errInvalidResponse
+	}
+	if err := parseRPCError(workResult.Error); err != nil {
+		return false, err
+	}
 
 	if workResult.Result {
 		log.Println("Job submitted.")
 	} else {
 		log.Println("Job rejected.")
 	}
+	return workResult.Result, nil
 }
 
-func GetWorkHead() (*types.Header, string) {
-	getWorkInfo := RpcInfo{Method: "eth_getWork", Params: []string{}, Id: 1, Jsonrpc: "2.0"}
-	getWorkInfoBuffs, _ := json.Marshal(getWorkInfo)
-
-	req := new(http.Request)
-
-	if walletAddress == "" {
-		req, _ = http.NewRequest("POST", rpcUrl, bytes.NewBuffer(getWorkInfoBuffs))
-	} else {
-		req, _ = http.NewRequest("POST", rpcUrl+"/"+walletAddress+"/1", bytes.NewBuffer(getWorkInfoBuffs))
+func GetWorkHead(transport rpcTransport) (*types.Header, string, error) {
+	work, err := fetchWork(transport)
+	if err != nil {
+		return nil, "", err
 	}
+	return work.Header, work.Hash, nil
+}
 
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// fetchWork fetches the current work package with eth_getWork.
+func fetchWork(transport rpcTransport) (Work, error) {
+	getWorkInfo := RpcInfo{Method: "eth_getWork", Params: []interface{}{}, Id: 1, Jsonrpc: "2.0"}
 
+	body, err := transport.call(&getWorkInfo)
 	if err != nil {
-		return nil, ""
+		return Work{}, err
 	}
 
-	defer resp.Body.Close()
-	body, _ := io.ReadAll(resp.Body)
 	workReback := new(RpcReback)
 
-	json.Unmarshal(body, workReback)
+	if err := json.Unmarshal(body, workReback); err != nil {
+		return Work{}, errInvalidResponse
+	}
+	if err := parseRPCError(workReback.Error); err != nil {
+		return Work{}, err
+	}
+	if len(workReback.Result) == 0 {
+		return Work{}, errMiningDisabled
+	}
 
-	if len(workReback.Result) != 4 {
-		log.Println("Mining not enabled on Geth.")
-		os.Exit(1)
+	work, err := workFromPackage(workReback.Result)
+	if err != nil {
+		return Work{}, fmt.Errorf("invalid work package: %w", err)
+	}
+	return work, nil
+}
+
+// workFromPackage validates a getWork package, as returned by eth_getWork and
+// pushed by --miner.notify, and converts it into a job. Older nodes and pools
+// leave out the block number, the first block of the seed hash's epoch then
+// stands in for it, with the work not marked exact.
+// Otherwise the seed hash has to match the block number, or the endpoint runs a
+// different ethash variant and the work is refused with errSeedMismatch.
+func workFromPackage(result []string) (Work, error) {
+	if len(result) != 3 && len(result) != 4 {
+		return Work{}, fmt.Errorf("expected 3 or 4 elements, got %d", len(result))
+	}
+	for i, name := range []string{"header hash", "seed hash"} {
+		if blob, err := hexutil.Decode(result[i]); err != nil || len(blob) != common.HashLength {
+			return Work{}, fmt.Errorf("invalid %s: %q", name, result[i])
+		}
 	}
+	target, ok := new(big.Int).SetString(strings.TrimPrefix(result[2], "0x"), 16)
+	if !ok || target.Sign() <= 0 || target.BitLen() > 256 {
+		return Work{}, fmt.Errorf("invalid target: %q", result[2])
+	}
+	seed := common.FromHex(result[1])
 
+	number := new(big.Int)
+	if len(result) == 3 {
+		epoch, ok := seedEpoch(seed)
+		if !ok {
+			return Work{}, fmt.Errorf("%w: unknown seed hash %s", errSeedMismatch, result[1])
+		}
+		number.SetUint64(epoch * epochLength)
+	} else {
+		// Seed hashes beyond the known epochs would take ages to compute
+		if _, ok := number.SetString(strings.TrimPrefix(result[3], "0x"), 16); !ok || !number.IsUint64() || number.Uint64()/epochLength >= maxEpoch {
+			return Work{}, fmt.Errorf("invalid block number: %q", result[3])
+		}
+		if !bytes.Equal(seed, seedHash(number.Uint64())) {
+			return Work{}, fmt.Errorf("%w: seed hash %s for block %v", errSeedMismatch, result[1], number)
+		}
+	}
 	newHeader := new(types.Header)
-	newHeader.Number = util.HexToBig(workReback.Result[3])
-	newHeader.Difficulty = util.TargetHexToDiff(workReback.Result[2])
+	newHeader.Number = number
+	newHeader.Difficulty = util.TargetHexToDiff(result[2])
 
-	return newHeader, workReback.Result[0]
+	return Work{Header: newHeader, Hash: result[0], exact: len(result) == 4}, nil
 }
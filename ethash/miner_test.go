@@ -0,0 +1,73 @@
+package ethash
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// Tests that getWork packages with and without a block number are converted into
+// jobs, and that seed hashes not belonging to the block are refused.
+func TestWorkFromPackage(t *testing.T) {
+	var (
+		hash   = "0x" + "11" + "00000000000000000000000000000000000000000000000000000000000000"
+		target = "0x0001000000000000000000000000000000000000000000000000000000000000"
+		seed   = func(block uint64) string { return hexutil.Encode(seedHash(block)) }
+		beyond = uint64(maxEpoch) * epochLength
+	)
+	tests := []struct {
+		result   []string
+		number   uint64
+		exact    bool
+		mismatch bool // Whether the package is refused with errSeedMismatch
+		fail     bool // Whether the package is refused for any other reason
+	}{
+		// Packages without a block number take it from the seed hash
+		{result: []string{hash, seed(0), target}, number: 0},
+		{result: []string{hash, seed(5*epochLength + 17), target}, number: 5 * epochLength},
+		{result: []string{hash, "0x" + "ff" + hash[4:], target}, mismatch: true},
+
+		// Packages with a block number have to match the seed hash
+		{result: []string{hash, seed(12345678), target, "0xbc614e"}, number: 12345678, exact: true},
+		{result: []string{hash, seed(0), target, "0x0"}, number: 0, exact: true},
+		{result: []string{hash, seed(12345678 - epochLength), target, "0xbc614e"}, mismatch: true},
+		{result: []string{hash, seed(0), target, "0xbc614e"}, mismatch: true},
+		{result: []string{hash, seed(0), target, hexutil.EncodeUint64(beyond)}, fail: true},
+		{result: []string{hash, seed(0), target, "0xzz"}, fail: true},
+
+		// Malformed packages
+		{result: []string{hash, seed(0)}, fail: true},
+		{result: []string{hash, seed(0), target, "0x0", "0x0"}, fail: true},
+		{result: []string{hash[:10], seed(0), target}, fail: true},
+		{result: []string{hash, seed(0)[:10], target}, fail: true},
+		{result: []string{hash, seed(0), "0x0"}, fail: true},
+		{result: []string{hash, seed(0), "0x1" + target[2:]}, fail: true},
+	}
+	wantDifficulty := new(big.Int).Lsh(big.NewInt(1), 16)
+	for i, tt := range tests {
+		work, err := workFromPackage(tt.result)
+		switch {
+		case tt.mismatch:
+			if !errors.Is(err, errSeedMismatch) {
+				t.Errorf("test %d: error %v, want seed mismatch", i, err)
+			}
+			continue
+		case tt.fail:
+			if err == nil || errors.Is(err, errSeedMismatch) {
+				t.Errorf("test %d: error %v, want invalid package", i, err)
+			}
+			continue
+		case err != nil:
+			t.Errorf("test %d: unexpected error: %v", i, err)
+			continue
+		}
+		if work.Header.Number.Uint64() != tt.number || work.exact != tt.exact {
+			t.Errorf("test %d: block %v (exact %v), want %d (exact %v)", i, work.Header.Number, work.exact, tt.number, tt.exact)
+		}
+		if work.Hash != hash || work.Header.Difficulty.Cmp(wantDifficulty) != 0 {
+			t.Errorf("test %d: hash %s difficulty %v, want %s %v", i, work.Hash, work.Header.Difficulty, hash, wantDifficulty)
+		}
+	}
+}
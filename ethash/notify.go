@@ -0,0 +1,98 @@
+package ethash
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	maxNotificationSize  = 64 * 1024       // Largest work notification body accepted
+	notificationDeadline = 1 * time.Second // Time to wait for a getWork endpoint to take pushed work
+)
+
+// workReceiver handles the work packages POSTed by nodes running with
+// --miner.notify, in the format sent by remoteSealer.notifyWork. Every getWork
+// endpoint has its own path, its position in the endpoint list like /2, so
+// pushed work is only mined and submitted on the node that issued it. With a
+// single getWork endpoint, / works as well.
+type workReceiver struct {
+	pushed map[string]chan<- Work // Work channels of the getWork endpoints, by path
+	names  map[string]string      // Endpoint names for logging, by path
+	paths  []string               // Paths in the order of the endpoints
+}
+
+func newWorkReceiver() *workReceiver {
+	return &workReceiver{pushed: make(map[string]chan<- Work), names: make(map[string]string)}
+}
+
+// add routes the work pushed to the path of the endpoint at the given position
+// to pushed.
+func (r *workReceiver) add(position int, name string, pushed chan<- Work) {
+	path := "/" + strconv.Itoa(position)
+	r.pushed[path], r.names[path] = pushed, name
+	r.paths = append(r.paths, path)
+}
+
+// listen starts accepting work notifications on addr.
+func (r *workReceiver) listen(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if len(r.paths) == 1 {
+		r.pushed["/"], r.names["/"] = r.pushed[r.paths[0]], r.names[r.paths[0]]
+		log.Println("Listening for work notifications on", listener.Addr())
+	} else {
+		for _, path := range r.paths {
+			log.Println("Listening for work notifications for", r.names[path], "on http://"+listener.Addr().String()+path)
+		}
+	}
+	go func() {
+		err := http.Serve(listener, r)
+		log.Println("Work notification listener stopped:", err)
+	}()
+	return nil
+}
+
+func (r *workReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	pushed, ok := r.pushed[req.URL.Path]
+	if !ok {
+		log.Println("Work notification from", req.RemoteAddr, "for unknown endpoint", req.URL.Path)
+		http.Error(w, "unknown endpoint, push work to /<position of the endpoint>", http.StatusNotFound)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, maxNotificationSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var result []string
+	if err := json.Unmarshal(body, &result); err != nil {
+		log.Println("Invalid work notification from", req.RemoteAddr, "-", err)
+		http.Error(w, "invalid work package", http.StatusBadRequest)
+		return
+	}
+	work, err := workFromPackage(result)
+	if err != nil {
+		log.Println("Invalid work notification from", req.RemoteAddr, "-", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Only a running getWork poller takes pushed work
+	select {
+	case pushed <- work:
+		w.WriteHeader(http.StatusOK)
+	case <-time.After(notificationDeadline):
+		log.Println("Work notification from", req.RemoteAddr, "ignored, endpoint", r.names[req.URL.Path], "not active")
+		http.Error(w, "endpoint not active", http.StatusServiceUnavailable)
+	}
+}
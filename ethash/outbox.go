@@ -0,0 +1,204 @@
+package ethash
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// outboxFile is the file in the dataset directory that keeps the solutions
+// which couldn't be delivered yet across restarts.
+const outboxFile = "outbox.json"
+
+// pendingSolutions holds the solutions for getWork endpoints waiting for a node
+// to answer, nil if there's no outbox.
+var pendingSolutions *outbox
+
+// pendingSolution is a solution that no node answered yet.
+type pendingSolution struct {
+	Nonce     types.BlockNonce `json:"nonce"`
+	Hash      string           `json:"hash"`
+	MixDigest common.Hash      `json:"mixDigest"`
+	Number    uint64           `json:"number"` // Block number, 0 if the job didn't tell it
+}
+
+// outbox keeps solutions that couldn't be submitted because no node could be
+// reached, persisted on disk, and retries them with backoff until a node
+// answers or the chain moved more than staleThreshold blocks past them. Those
+// for jobs without a block number are kept until a node answers.
+type outbox struct {
+	path string
+
+	lock    sync.Mutex
+	pending []pendingSolution
+	head    uint64        // Number of the latest known block
+	wake    chan struct{} // Notifies the retry loop of new solutions
+}
+
+// openOutbox creates an outbox persisted in the given file, loading the
+// solutions left over from a previous run.
+func openOutbox(path string) (*outbox, error) {
+	o := &outbox{path: path, wake: make(chan struct{}, 1)}
+
+	blob, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(blob, &o.pending); err != nil {
+		return nil, err
+	}
+	if len(o.pending) > 0 {
+		log.Println("Loaded", len(o.pending), "undelivered solutions from", path)
+		o.wake <- struct{}{}
+	}
+	return o, nil
+}
+
+// add queues a solution for retrying, unless it's queued already, as when
+// broadcasting to several nodes none of which answered.
+func (o *outbox) add(solution pendingSolution) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	for _, pending := range o.pending {
+		if pending.Hash == solution.Hash && pending.Nonce == solution.Nonce {
+			return
+		}
+	}
+	o.pending = append(o.pending, solution)
+	if err := o.save(); err != nil {
+		log.Println("Unable to persist undelivered solution:", err)
+	}
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// setHead updates the number of the latest known block.
+func (o *outbox) setHead(number uint64) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if number > o.head {
+		o.head = number
+	}
+}
+
+// save writes the pending solutions to disk, removing the file once there are
+// none left. The lock must be held.
+func (o *outbox) save() error {
+	if len(o.pending) == 0 {
+		if err := os.Remove(o.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	blob, err := json.Marshal(o.pending)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(o.path), 0755); err != nil {
+		return err
+	}
+	// Write to a temporary file first so a crash can't leave a truncated outbox
+	tmp := o.path + ".tmp"
+	if err := os.WriteFile(tmp, blob, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, o.path)
+}
+
+// run retries the pending solutions with the given clients, in order of
+// priority, until all are delivered or stale.
+func (o *outbox) run(clients []*rpcClient) {
+	retry := backoff{min: retryMinDelay, max: retryMaxDelay}
+	for {
+		o.lock.Lock()
+		pending := len(o.pending)
+		o.lock.Unlock()
+
+		if pending == 0 {
+			retry.reset()
+			<-o.wake
+		} else {
+			time.Sleep(retry.next())
+		}
+		o.flush(clients)
+	}
+}
+
+// flush tries to deliver every pending solution once, dropping the stale ones.
+func (o *outbox) flush(clients []*rpcClient) {
+	o.lock.Lock()
+	pending := append([]pendingSolution(nil), o.pending...)
+	head := o.head
+	o.lock.Unlock()
+
+	done := make(map[pendingSolution]bool)
+	for _, solution := range pending {
+		if solution.Number > 0 && head > solution.Number+staleThreshold {
+			log.Println("Dropping undelivered solution for block", solution.Number, "at head", head)
+			done[solution] = true
+			continue
+		}
+		if deliverSolution(solution, clients) {
+			done[solution] = true
+		}
+	}
+	if len(done) == 0 {
+		return
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	remaining := o.pending[:0]
+	for _, solution := range o.pending {
+		if !done[solution] {
+			remaining = append(remaining, solution)
+		}
+	}
+	o.pending = remaining
+	if err := o.save(); err != nil {
+		log.Println("Unable to persist undelivered solutions:", err)
+	}
+}
+
+// deliverSolution submits a solution to the first of the clients whose node
+// answers, whether it accepts the solution or not, and reports whether any did.
+func deliverSolution(solution pendingSolution, clients []*rpcClient) bool {
+	nonceHex, _ := solution.Nonce.MarshalText()
+	mixHex, _ := solution.MixDigest.MarshalText()
+	header := types.Header{Number: new(big.Int).SetUint64(solution.Number)}
+
+	name := "block " + header.Number.String()
+	if solution.Number == 0 {
+		name = "job " + solution.Hash
+	}
+
+	for _, client := range clients {
+		accepted, err := SubmitWork(client, string(nonceHex), solution.Hash, string(mixHex), header)
+
+		var callErr *rpcCallError
+		switch {
+		case errors.As(err, &callErr):
+			logRPCError("Retried solution for "+name+" rejected:", err)
+			return true
+		case err == nil:
+			log.Println("Retried solution for", name, "delivered to", client.name, "- accepted:", accepted)
+			return true
+		}
+	}
+	return false
+}
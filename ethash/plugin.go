@@ -0,0 +1,233 @@
+package ethash
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// pluginExitTimeout is the time a plugin gets to exit after its stdin was closed
+// before it's killed.
+const pluginExitTimeout = 5 * time.Second
+
+// pluginJob is a job announced by a work source plugin. The fields mirror the
+// eth_getWork package, the block number may be left out.
+type pluginJob struct {
+	Job    string `json:"job"`    // Plugin assigned job id, sent back with solutions
+	Hash   string `json:"hash"`   // Header hash (seal hash) of the block to mine
+	Seed   string `json:"seed"`   // Seed hash of the ethash epoch
+	Target string `json:"target"` // Boundary condition, 2^256 / difficulty
+	Number string `json:"number"` // Hex block number, optional
+}
+
+// pluginSource is the work source running an external command, exchanging line
+// delimited JSON with it over stdin and stdout. The plugin announces jobs as
+//
+//	{"method":"job","params":{"job":"7","hash":"0x…","seed":"0x…","target":"0x…","number":"0x…"}}
+//
+// and is handed solutions as the eth_submitWork call of a node, with the job id
+// added:
+//
+//	{"jsonrpc":"2.0","method":"eth_submitWork","params":["0x<nonce>","0x<hash>","0x<mix digest>"],"id":1,"job":"7"}
+//
+// which it answers with {"id":1,"result":true}, or a false result and an error.
+// The hash rate is reported with eth_submitHashrate the same way. The plugin is
+// restarted when it exits, its stderr is passed through.
+type pluginSource struct {
+	args []string
+
+	lock     sync.Mutex
+	conn     *stratumConn
+	lastHash string // Header hash of the last job delivered
+	getWork  chan<- Work
+	quit     <-chan struct{}
+}
+
+// newPluginSource creates a work source for an exec: endpoint, the command line
+// of the plugin split at spaces.
+func newPluginSource(rawurl string) (*pluginSource, error) {
+	args := strings.Fields(strings.TrimPrefix(rawurl, "exec:"))
+	if len(args) == 0 {
+		return nil, errors.New("missing plugin command")
+	}
+	return &pluginSource{args: args}, nil
+}
+
+func (s *pluginSource) Run(getWork chan<- Work, quit <-chan struct{}) error {
+	s.lock.Lock()
+	s.getWork, s.quit, s.lastHash = getWork, quit, ""
+	s.lock.Unlock()
+
+	return keepConnected("Plugin", s.connect, quit)
+}
+
+func (s *pluginSource) Probe() error {
+	_, err := exec.LookPath(s.args[0])
+	return err
+}
+
+// connect starts the plugin process.
+func (s *pluginSource) connect() (*stratumConn, error) {
+	pipe, err := startPlugin(s.args)
+	if err != nil {
+		return nil, err
+	}
+	conn := newStratumConn(pipe, s.handle)
+
+	s.lock.Lock()
+	s.conn = conn
+	s.lock.Unlock()
+
+	log.Println("Started work source plugin", s.args[0])
+
+	go reportHashrate(poolHashrateInterval, func(params []interface{}) error {
+		return submitPoolHashrate(conn, "", params)
+	}, conn.closed)
+
+	return conn, nil
+}
+
+// handle processes the jobs announced by the plugin.
+func (s *pluginSource) handle(msg *stratumMessage) {
+	if msg.Method != "job" {
+		if msg.Method != "" {
+			log.Println("Unsupported plugin method:", msg.Method)
+		}
+		return
+	}
+	var job pluginJob
+	if err := json.Unmarshal(msg.Params, &job); err != nil {
+		log.Println("Invalid plugin job:", string(msg.Params))
+		return
+	}
+	result := []string{job.Hash, job.Seed, job.Target}
+	if job.Number != "" {
+		result = append(result, job.Number)
+	}
+	work, err := workFromPackage(result)
+	if err != nil {
+		log.Println("Refusing plugin job:", err)
+		return
+	}
+	work.Job = job.Job
+
+	s.lock.Lock()
+	if work.Hash == s.lastHash {
+		s.lock.Unlock()
+		return
+	}
+	s.lastHash = work.Hash
+	getWork, quit := s.getWork, s.quit
+	s.lock.Unlock()
+
+	select {
+	case getWork <- work:
+	case <-quit:
+	}
+}
+
+func (s *pluginSource) Submit(work Work, nonce types.BlockNonce, mixDigest common.Hash) bool {
+	s.lock.Lock()
+	conn := s.conn
+	s.lock.Unlock()
+
+	if conn == nil {
+		log.Println("Solution discarded, plugin not running.")
+		return false
+	}
+	nonceHex, _ := nonce.MarshalText()
+	mixHex, _ := mixDigest.MarshalText()
+
+	return conn.submit("Solution", &RpcInfo{Jsonrpc: "2.0", Method: "eth_submitWork", Params: []interface{}{string(nonceHex), work.Hash, string(mixHex)}, Job: work.Job})
+}
+
+// pluginPipe is the connection to a plugin process, reading from its stdout and
+// writing to its stdin.
+type pluginPipe struct {
+	*os.File // Read end of the plugin's stdout
+
+	name    string
+	process *os.Process
+	stdin   *os.File
+	exited  chan struct{}
+}
+
+// startPlugin launches the plugin process and connects to its stdin and stdout.
+func startPlugin(args []string) (*pluginPipe, error) {
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		stdinR.Close()
+		stdinW.Close()
+		return nil, err
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdinR, stdoutW, os.Stderr
+
+	err = cmd.Start()
+
+	// The plugin holds its own copies of its ends of the pipes
+	stdinR.Close()
+	stdoutW.Close()
+	if err != nil {
+		stdinW.Close()
+		stdoutR.Close()
+		return nil, err
+	}
+	p := &pluginPipe{File: stdoutR, name: args[0], process: cmd.Process, stdin: stdinW, exited: make(chan struct{})}
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			log.Println("Work source plugin", p.name, "exited:", err)
+		}
+		close(p.exited)
+	}()
+	return p, nil
+}
+
+func (p *pluginPipe) Write(b []byte) (int, error) {
+	return p.stdin.Write(b)
+}
+
+// Close closes the plugin's stdin and waits for it to exit, killing it if it
+// doesn't do so in time, as plugins are expected to exit at the end of input.
+func (p *pluginPipe) Close() error {
+	p.stdin.Close()
+	select {
+	case <-p.exited:
+	case <-time.After(pluginExitTimeout):
+		log.Println("Killing work source plugin", p.name)
+		p.process.Kill()
+		<-p.exited
+	}
+	return p.File.Close()
+}
+
+func (p *pluginPipe) SetDeadline(t time.Time) error {
+	p.File.SetReadDeadline(t)
+	return p.stdin.SetWriteDeadline(t)
+}
+
+func (p *pluginPipe) SetWriteDeadline(t time.Time) error {
+	return p.stdin.SetWriteDeadline(t)
+}
+
+func (p *pluginPipe) LocalAddr() net.Addr  { return pluginAddr(p.name) }
+func (p *pluginPipe) RemoteAddr() net.Addr { return pluginAddr(p.name) }
+
+// pluginAddr is the address of a plugin connection, the plugin command.
+type pluginAddr string
+
+func (a pluginAddr) Network() string { return "exec" }
+func (a pluginAddr) String() string  { return string(a) }
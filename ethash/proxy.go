@@ -0,0 +1,325 @@
+package ethash
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// proxyDirect disables the default proxy for an endpoint.
+const proxyDirect = "direct"
+
+// socksReplies are the reasons a SOCKS5 proxy gives for refusing a connection.
+var socksReplies = []string{
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// proxyError is returned when a proxy doesn't open a tunnel to an endpoint.
+type proxyError struct {
+	proxy  string // Proxy URL without credentials
+	addr   string // Endpoint address the tunnel was requested to
+	reason string
+}
+
+func (e *proxyError) Error() string {
+	return fmt.Sprintf("proxy %s refused the tunnel to %s: %s", e.proxy, e.addr, e.reason)
+}
+
+// splitProxy splits the proxy given for an endpoint as a #proxy= suffix off its
+// URL.
+func splitProxy(rawurl string) (string, string) {
+	if i := strings.LastIndex(rawurl, "#proxy="); i >= 0 {
+		return rawurl[:i], rawurl[i+len("#proxy="):]
+	}
+	return rawurl, ""
+}
+
+// resolveProxy returns the proxy to reach the endpoint at rawurl through, nil to
+// connect directly. The proxy given for the endpoint comes first, then the one
+// for all endpoints, then the environment. Either may be "direct" to not use
+// a proxy. Only network endpoints go through proxies.
+func resolveProxy(rawurl string, proxy string, fallback string) (*url.URL, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Host == "" || isIPCPath(rawurl) {
+		return nil, nil
+	}
+	switch {
+	case proxy != "":
+	case fallback != "":
+		proxy = fallback
+	default:
+		proxy = proxyFromEnvironment(u)
+	}
+	if proxy == "" || proxy == proxyDirect {
+		return nil, nil
+	}
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	p, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %s", redactURL(proxy))
+	}
+	switch p.Scheme {
+	case "http", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, expected http, socks5 or socks5h", p.Scheme)
+	}
+	if p.Port() == "" {
+		port := "1080"
+		if p.Scheme == "http" {
+			port = "80"
+		}
+		p.Host = net.JoinHostPort(p.Hostname(), port)
+	}
+	return p, nil
+}
+
+// proxyFromEnvironment returns the proxy configured in the environment for the
+// endpoint at u: HTTP_PROXY or HTTPS_PROXY for HTTP and WebSocket endpoints, and
+// ALL_PROXY for these and TCP pools. Like Go's HTTP client it never proxies
+// connections to the local host, nor to hosts matching NO_PROXY.
+func proxyFromEnvironment(u *url.URL) string {
+	host := u.Hostname()
+	if host == "localhost" || (net.ParseIP(host) != nil && net.ParseIP(host).IsLoopback()) || noProxy(host) {
+		return ""
+	}
+	var proxy string
+	switch u.Scheme {
+	case "http", "ws":
+		proxy = getenv("HTTP_PROXY", "http_proxy")
+	case "https", "wss":
+		proxy = getenv("HTTPS_PROXY", "https_proxy")
+	}
+	if proxy == "" {
+		proxy = getenv("ALL_PROXY", "all_proxy")
+	}
+	return proxy
+}
+
+// noProxy reports whether NO_PROXY excludes the host from proxying, either by
+// name, with its subdomains, or with "*" for all hosts.
+func noProxy(host string) bool {
+	for _, entry := range strings.Split(getenv("NO_PROXY", "no_proxy"), ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		switch {
+		case entry == "":
+		case entry == "*":
+			return true
+		case strings.EqualFold(host, strings.TrimPrefix(entry, ".")):
+			return true
+		case strings.HasSuffix(strings.ToLower(host), "."+strings.TrimPrefix(entry, ".")):
+			return true
+		}
+	}
+	return false
+}
+
+// getenv returns the value of the first of the environment variables set.
+func getenv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// dialThrough opens a TCP connection to addr, through proxy if not nil.
+func dialThrough(ctx context.Context, dialer *net.Dialer, proxy *url.URL, addr string) (net.Conn, error) {
+	if proxy == nil {
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", proxy.Host)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach proxy %s: %w", redactURL(proxy.String()), err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	tunnel := conn
+	if proxy.Scheme == "http" {
+		tunnel, err = dialConnect(conn, proxy, addr)
+	} else {
+		err = dialSOCKS5(ctx, conn, proxy, addr)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return tunnel, nil
+}
+
+// dialConnect asks an HTTP proxy for a tunnel to addr with a CONNECT request.
+func dialConnect(conn net.Conn, proxy *url.URL, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		req.SetBasicAuth(proxy.User.Username(), password)
+		req.Header["Proxy-Authorization"] = req.Header["Authorization"]
+		delete(req.Header, "Authorization")
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("unable to reach proxy %s: %w", redactURL(proxy.String()), err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach proxy %s: %w", redactURL(proxy.String()), err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &proxyError{proxy: redactURL(proxy.String()), addr: addr, reason: resp.Status}
+	}
+	// Anything the endpoint sent right away may already be buffered
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// dialSOCKS5 asks a SOCKS5 proxy for a tunnel to addr. With socks5:// proxies
+// host names are resolved locally, with socks5h:// by the proxy.
+func dialSOCKS5(ctx context.Context, conn net.Conn, proxy *url.URL, addr string) error {
+	name := redactURL(proxy.String())
+	fail := func(reason string) error {
+		return &proxyError{proxy: name, addr: addr, reason: reason}
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	portnum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port in %s", addr)
+	}
+	// Negotiate the authentication, a username and password if given
+	methods := []byte{0x00}
+	if proxy.User != nil {
+		methods = []byte{0x00, 0x02}
+	}
+	reply := make([]byte, 2)
+	if _, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return fmt.Errorf("unable to reach proxy %s: %w", name, err)
+	}
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("unable to reach proxy %s: %w", name, err)
+	}
+	if reply[0] != 0x05 {
+		return fmt.Errorf("proxy %s is not a SOCKS5 proxy", name)
+	}
+	switch {
+	case reply[1] == 0x00:
+	case reply[1] == 0x02 && proxy.User != nil:
+		user := proxy.User.Username()
+		password, _ := proxy.User.Password()
+		if len(user) > 255 || len(password) > 255 {
+			return fail("username or password too long")
+		}
+		msg := append([]byte{0x01, byte(len(user))}, user...)
+		msg = append(append(msg, byte(len(password))), password...)
+		if _, err := conn.Write(msg); err != nil {
+			return fmt.Errorf("unable to reach proxy %s: %w", name, err)
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return fmt.Errorf("unable to reach proxy %s: %w", name, err)
+		}
+		if reply[1] != 0x00 {
+			return fail("authentication failed")
+		}
+	default:
+		return fail("authentication required")
+	}
+	// Request the tunnel
+	msg := []byte{0x05, 0x01, 0x00}
+	ip := net.ParseIP(host)
+	if ip == nil && proxy.Scheme == "socks5" {
+		ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+		if err != nil {
+			return err
+		}
+		ip = ips[0]
+	}
+	switch {
+	case ip == nil:
+		if len(host) > 255 {
+			return fmt.Errorf("host name too long: %s", host)
+		}
+		msg = append(append(msg, 0x03, byte(len(host))), host...)
+	case ip.To4() != nil:
+		msg = append(append(msg, 0x01), ip.To4()...)
+	default:
+		msg = append(append(msg, 0x04), ip.To16()...)
+	}
+	msg = binary.BigEndian.AppendUint16(msg, uint16(portnum))
+
+	if _, err := conn.Write(msg); err != nil {
+		return fmt.Errorf("unable to reach proxy %s: %w", name, err)
+	}
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("unable to reach proxy %s: %w", name, err)
+	}
+	if header[1] != 0x00 {
+		if int(header[1]) < len(socksReplies) {
+			return fail(socksReplies[header[1]])
+		}
+		return fail("reply code " + strconv.Itoa(int(header[1])))
+	}
+	// Skip the address the proxy bound for the tunnel
+	var skip int
+	switch header[3] {
+	case 0x01:
+		skip = net.IPv4len + 2
+	case 0x04:
+		skip = net.IPv6len + 2
+	case 0x03:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return fmt.Errorf("unable to reach proxy %s: %w", name, err)
+		}
+		skip = int(length[0]) + 2
+	default:
+		return fmt.Errorf("invalid reply from proxy %s", name)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, skip)); err != nil {
+		return fmt.Errorf("unable to reach proxy %s: %w", name, err)
+	}
+	return nil
+}
+
+// bufferedConn is a connection with data already read into a buffer.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,190 @@
+package ethash
+
+import (
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	raceStatsInterval = 10 * time.Minute // Time between two logs of the announcement statistics
+	raceSeenBlocks    = 16               // Number of blocks below the highest one to remember job hashes for
+)
+
+// raceSource fetches work from all of its sources at the same time, meant for
+// solo mining against several nodes. Every job is only mined once, no matter
+// how many nodes announced it, and jobs for blocks below the highest one seen
+// are dropped as their parent was already superseded. Jobs without a block
+// number can't be ordered and are always mined.
+type raceSource struct {
+	sources []WorkSource
+	names   []string // Endpoint URLs for logging, without credentials
+
+	lock  sync.Mutex
+	stats []raceStats
+}
+
+// raceStats counts how an endpoint fared in delivering work.
+type raceStats struct {
+	jobs  int // Number of distinct jobs delivered first
+	first int // Number of new blocks announced before any other endpoint
+}
+
+// raceJob is a job tagged with the index of the source that delivered it.
+type raceJob struct {
+	index int
+	work  Work
+}
+
+func newRaceSource(sources []WorkSource, names []string) *raceSource {
+	return &raceSource{sources: sources, names: names, stats: make([]raceStats, len(sources))}
+}
+
+func (r *raceSource) Run(getWork chan<- Work, quit <-chan struct{}) error {
+	statsTimer := time.NewTicker(raceStatsInterval)
+	defer statsTimer.Stop()
+
+	var (
+		jobs    = make(chan raceJob)
+		seen    = make(map[string]uint64) // Job hashes already delivered, with their block numbers
+		highest *big.Int
+	)
+	for i := range r.sources {
+		go r.runSource(i, jobs, quit)
+	}
+	for {
+		select {
+		case job := <-jobs:
+			work := job.work
+			if _, ok := seen[work.Hash]; ok {
+				continue
+			}
+			r.lock.Lock()
+			r.stats[job.index].jobs++
+			r.lock.Unlock()
+
+			if !work.exact {
+				// Remembered as long as the blocks seen at the same time
+				seen[work.Hash] = 0
+				if highest != nil {
+					seen[work.Hash] = highest.Uint64()
+				}
+			} else {
+				number := work.Header.Number
+				seen[work.Hash] = number.Uint64()
+
+				if highest != nil && number.Cmp(highest) < 0 {
+					continue
+				}
+				if highest == nil || number.Cmp(highest) > 0 {
+					highest = new(big.Int).Set(number)
+					log.Println("Block", number, "first announced by", r.names[job.index])
+
+					r.lock.Lock()
+					r.stats[job.index].first++
+					r.lock.Unlock()
+
+					for hash, n := range seen {
+						if n+raceSeenBlocks < number.Uint64() {
+							delete(seen, hash)
+						}
+					}
+				}
+			}
+			select {
+			case getWork <- work:
+			case <-quit:
+				return nil
+			}
+
+		case <-statsTimer.C:
+			r.logStats()
+
+		case <-quit:
+			return nil
+		}
+	}
+}
+
+// runSource keeps the source with the given index running until quit is
+// closed, restarting it whenever it gives up on its endpoint.
+func (r *raceSource) runSource(index int, jobs chan<- raceJob, quit <-chan struct{}) {
+	var (
+		source = r.sources[index]
+		local  = make(chan Work)
+		retry  = backoff{min: retryMinDelay, max: retryMaxDelay}
+	)
+	go func() {
+		for {
+			select {
+			case work := <-local:
+				work.source = source
+				select {
+				case jobs <- raceJob{index: index, work: work}:
+				case <-quit:
+					return
+				}
+			case <-quit:
+				return
+			}
+		}
+	}()
+	for {
+		// Every run gets its own stop channel, ending its background checks
+		// once it gave up
+		var (
+			stop = make(chan struct{})
+			done = make(chan error, 1)
+		)
+		go func() { done <- source.Run(local, stop) }()
+
+		var err error
+		select {
+		case err = <-done:
+			close(stop)
+		case <-quit:
+			close(stop)
+			<-done
+			return
+		}
+		log.Println("Endpoint", r.names[index], "failed:", err)
+
+		select {
+		case <-time.After(retry.next()):
+		case <-quit:
+			return
+		}
+	}
+}
+
+// logStats logs for every endpoint how often it was first to deliver work.
+func (r *raceSource) logStats() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for i, stats := range r.stats {
+		log.Println("Endpoint", r.names[i], "first to announce", stats.first, "blocks and", stats.jobs, "jobs")
+	}
+}
+
+func (r *raceSource) Submit(work Work, nonce types.BlockNonce, mixDigest common.Hash) bool {
+	if work.source == nil {
+		log.Println("Solution discarded, job has no endpoint.")
+		return false
+	}
+	return work.source.Submit(work, nonce, mixDigest)
+}
+
+func (r *raceSource) Probe() error {
+	var err error
+	for _, source := range r.sources {
+		if err = source.Probe(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
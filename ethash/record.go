@@ -0,0 +1,173 @@
+package ethash
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errReplayEnded = errors.New("end of recorded traffic")
+
+// trafficRecorder writes the calls to getWork endpoints to a file if recording,
+// nil otherwise.
+var trafficRecorder *recorder
+
+// recordedCall is a call to a node as kept in a recording, one JSON object per
+// line. Calls that failed without a response have the error instead.
+type recordedCall struct {
+	Time     time.Time       `json:"time"`
+	Endpoint string          `json:"endpoint"`
+	Request  json.RawMessage `json:"request"`
+	Response json.RawMessage `json:"response,omitempty"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// recorder appends the calls made to nodes to a recording.
+type recorder struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+// openRecorder opens the recording in the given file, appending to it if it
+// already exists.
+func openRecorder(path string) (*recorder, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &recorder{file: file}, nil
+}
+
+// record appends a call with its outcome. Responses that aren't valid JSON are
+// kept as a string.
+func (r *recorder) record(endpoint string, req *RpcInfo, res []byte, err error) error {
+	call := recordedCall{Time: time.Now(), Endpoint: endpoint}
+	call.Request, _ = json.Marshal(req)
+	switch {
+	case err != nil:
+		call.Error = err.Error()
+	case json.Valid(res):
+		call.Response = res
+	default:
+		call.Response, _ = json.Marshal(string(res))
+	}
+	blob, _ := json.Marshal(call)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	_, err = r.file.Write(append(blob, '\n'))
+	return err
+}
+
+// recordingTransport records every call made over a transport.
+type recordingTransport struct {
+	rpcTransport
+	endpoint string
+	recorder *recorder
+}
+
+// newRecordingTransport wraps a transport to record its calls, keeping head
+// subscriptions working. Notifications aren't recorded.
+func newRecordingTransport(transport rpcTransport, endpoint string, recorder *recorder) rpcTransport {
+	recording := &recordingTransport{rpcTransport: transport, endpoint: endpoint, recorder: recorder}
+	if sub, ok := transport.(subscriber); ok {
+		return struct {
+			*recordingTransport
+			subscriber
+		}{recording, sub}
+	}
+	return recording
+}
+
+func (t *recordingTransport) call(req *RpcInfo) ([]byte, error) {
+	res, err := t.rpcTransport.call(req)
+	if err := t.recorder.record(t.endpoint, req, res, err); err != nil {
+		log.Println("Unable to record call:", err)
+	}
+	return res, err
+}
+
+// replayTransport plays a recording back as if it were the node. Every call is
+// answered with the next recorded response to a call of the same method, so the
+// order of the calls is kept per method even with background checks running.
+// Calls fail with errReplayEnded once the responses of their method ran out.
+type replayTransport struct {
+	lock  sync.Mutex
+	calls map[string][]recordedCall // Recorded calls not played back yet, by method
+}
+
+// openReplay loads the calls to the given endpoint from the recording in the
+// given file for playing them back. The endpoint may be left out if only one
+// was recorded.
+func openReplay(path string, endpoint string) (*replayTransport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var (
+		t         = &replayTransport{calls: make(map[string][]recordedCall)}
+		endpoints []string // Endpoints in the recording, in order of appearance
+		recorded  = make(map[string]bool)
+	)
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var (
+			call recordedCall
+			req  RpcInfo
+		)
+		if err := json.Unmarshal(scanner.Bytes(), &call); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if err := json.Unmarshal(call.Request, &req); err != nil {
+			return nil, fmt.Errorf("line %d: invalid request: %v", line, err)
+		}
+		if !recorded[call.Endpoint] {
+			recorded[call.Endpoint] = true
+			endpoints = append(endpoints, call.Endpoint)
+		}
+		if endpoint == "" || call.Endpoint == endpoint {
+			t.calls[req.Method] = append(t.calls[req.Method], call)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	switch {
+	case endpoint == "" && len(endpoints) > 1:
+		return nil, fmt.Errorf("recording of several endpoints, pick one with replay:%s#<endpoint> out of %s", path, strings.Join(endpoints, ", "))
+	case endpoint != "" && !recorded[endpoint]:
+		return nil, fmt.Errorf("no calls to %s in the recording", endpoint)
+	}
+	return t, nil
+}
+
+func (t *replayTransport) call(req *RpcInfo) ([]byte, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	calls := t.calls[req.Method]
+	if len(calls) == 0 {
+		return nil, errReplayEnded
+	}
+	call := calls[0]
+	t.calls[req.Method] = calls[1:]
+
+	if call.Error != "" {
+		return nil, errors.New(call.Error)
+	}
+	return call.Response, nil
+}
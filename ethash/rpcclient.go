@@ -0,0 +1,143 @@
+package ethash
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	retryMinDelay    = time.Second // Initial delay before retrying a failed endpoint
+	retryMaxDelay    = time.Minute // Upper bound of the delay between retries
+	breakerThreshold = 3           // Consecutive failed calls after which an endpoint is cut off
+)
+
+var (
+	errCircuitOpen     = errors.New("endpoint temporarily unavailable after repeated failures")
+	errInvalidResponse = errors.New("invalid response from node")
+	errMiningDisabled  = errors.New("mining not enabled on node")
+)
+
+// httpStatusError is returned when a node answers an HTTP request with a status
+// other than 200 OK.
+type httpStatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("unexpected HTTP status: %s", e.Status)
+}
+
+// backoff computes exponentially growing retry delays. Half of every delay is
+// randomized so that miners sharing an endpoint don't retry in lockstep.
+type backoff struct {
+	min, max time.Duration
+	attempt  int
+}
+
+// next returns the delay before the next retry.
+func (b *backoff) next() time.Duration {
+	delay := b.max
+	if b.attempt < 32 && b.min<<b.attempt < b.max {
+		delay = b.min << b.attempt
+		b.attempt++
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// reset starts over from the minimum delay after a success.
+func (b *backoff) reset() {
+	b.attempt = 0
+}
+
+// circuitBreaker cuts off an endpoint after breakerThreshold failed calls in a
+// row. Once the cool down, growing with every further failure, has expired a
+// single trial call is let through to check whether the endpoint recovered.
+type circuitBreaker struct {
+	lock      sync.Mutex
+	failures  int       // Number of consecutive failed calls
+	openUntil time.Time // Time until which calls are refused
+	trial     bool      // Whether a trial call is in flight
+	cooldown  backoff
+}
+
+// allow reports whether a call may be sent to the endpoint.
+func (b *circuitBreaker) allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.failures < breakerThreshold {
+		return true
+	}
+	if b.trial || time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+// record accounts the outcome of a call, returning the cool down if it cut off
+// the endpoint and whether it closed the breaker again.
+func (b *circuitBreaker) record(err error) (cooldown time.Duration, recovered bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.trial = false
+	if err == nil {
+		recovered = b.failures >= breakerThreshold
+		b.failures = 0
+		b.cooldown.reset()
+		return 0, recovered
+	}
+	if b.failures++; b.failures >= breakerThreshold {
+		cooldown = b.cooldown.next()
+		b.openUntil = time.Now().Add(cooldown)
+	}
+	return cooldown, false
+}
+
+// rpcClient is the client for the JSON-RPC calls of a getWork endpoint. It sends
+// calls over the transport matching the endpoint URL and trips a circuit breaker
+// when the endpoint keeps failing, so callers fail fast instead of piling up
+// requests on an unreachable node.
+type rpcClient struct {
+	name      string // Endpoint URL for logging, without credentials
+	transport rpcTransport
+	breaker   circuitBreaker
+}
+
+func newRPCClient(rawurl string, login poolLogin, auth *rpcAuth, proxy *url.URL) (*rpcClient, error) {
+	transport, err := newTransport(rawurl, login, auth, proxy)
+	if err != nil {
+		return nil, err
+	}
+	name := redactURL(rawurl)
+	if trafficRecorder != nil {
+		transport = newRecordingTransport(transport, name, trafficRecorder)
+	}
+	return &rpcClient{
+		name:      name,
+		transport: transport,
+		breaker:   circuitBreaker{cooldown: backoff{min: retryMinDelay, max: retryMaxDelay}},
+	}, nil
+}
+
+func (c *rpcClient) call(req *RpcInfo) ([]byte, error) {
+	if !c.breaker.allow() {
+		return nil, errCircuitOpen
+	}
+	blob, err := c.transport.call(req)
+
+	cooldown, recovered := c.breaker.record(err)
+	if cooldown > 0 {
+		log.Println("Endpoint", c.name, "keeps failing, pausing calls for", cooldown.Round(time.Second))
+	} else if recovered {
+		log.Println("Endpoint", c.name, "recovered.")
+	}
+	return blob, err
+}
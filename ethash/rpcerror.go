@@ -0,0 +1,182 @@
+package ethash
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// rpcErrorKind is the category of an error reported by a node or pool.
+type rpcErrorKind int
+
+const (
+	rpcErrOther        rpcErrorKind = iota // Anything not classified below
+	rpcErrStale                            // Solution for work that was already superseded
+	rpcErrInvalidPoW                       // Solution not meeting the target or with a wrong mix digest
+	rpcErrUnknownWork                      // Solution for work or a job the endpoint doesn't know
+	rpcErrUnauthorized                     // Missing or wrong credentials
+	rpcErrNotMining                        // Endpoint has no work to hand out
+	rpcErrRateLimited                      // Too many requests
+	rpcErrKinds
+)
+
+var rpcErrorKindNames = [rpcErrKinds]string{"other", "stale", "invalid PoW", "unknown work", "unauthorized", "not mining", "rate limited"}
+
+func (k rpcErrorKind) String() string {
+	if k < 0 || k >= rpcErrKinds {
+		return "unknown"
+	}
+	return rpcErrorKindNames[k]
+}
+
+var (
+	errStaleWork    = errors.New("stale work")
+	errUnknownWork  = errors.New("unknown work")
+	errUnauthorized = errors.New("unauthorized")
+	errRateLimited  = errors.New("rate limited")
+
+	// rpcErrorSentinels are the errors that classified RPC errors match with
+	// errors.Is, by category.
+	rpcErrorSentinels = [rpcErrKinds]error{
+		rpcErrStale:        errStaleWork,
+		rpcErrInvalidPoW:   errInvalidPoW,
+		rpcErrUnknownWork:  errUnknownWork,
+		rpcErrUnauthorized: errUnauthorized,
+		rpcErrNotMining:    errMiningDisabled,
+		rpcErrRateLimited:  errRateLimited,
+	}
+)
+
+// rpcCallError is the error member of a JSON-RPC or stratum response.
+type rpcCallError struct {
+	Code    int
+	Message string
+	Data    json.RawMessage
+	Kind    rpcErrorKind
+}
+
+func (e *rpcCallError) Error() string {
+	if e.Code == 0 {
+		return e.Message
+	}
+	if len(e.Data) > 0 && string(e.Data) != "null" {
+		return fmt.Sprintf("%s (code %d, data %s)", e.Message, e.Code, e.Data)
+	}
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// Is makes the error match the sentinel error of its category.
+func (e *rpcCallError) Is(target error) bool {
+	return target != nil && target == rpcErrorSentinels[e.Kind]
+}
+
+// Is makes HTTP statuses refusing the request match the sentinel errors for
+// unauthorized and rate limited calls.
+func (e *httpStatusError) Is(target error) bool {
+	switch e.Code {
+	case 401, 403:
+		return target == errUnauthorized
+	case 429:
+		return target == errRateLimited
+	}
+	return false
+}
+
+// parseRPCError decodes the error member of a response, which nodes send as
+// a {code, message, data} object and stratum pools also as a [code, message,
+// data] array or a plain string. It returns nil if there's no error.
+func parseRPCError(raw json.RawMessage) *rpcCallError {
+	if len(raw) == 0 || string(raw) == "null" || string(raw) == "false" {
+		return nil
+	}
+	e := new(rpcCallError)
+	var obj struct {
+		Code    int             `json:"code"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}
+	var arr []json.RawMessage
+	switch {
+	case json.Unmarshal(raw, &obj) == nil && obj.Message != "":
+		e.Code, e.Message, e.Data = obj.Code, obj.Message, obj.Data
+	case json.Unmarshal(raw, &arr) == nil && len(arr) > 1:
+		var code float64
+		json.Unmarshal(arr[0], &code)
+		if json.Unmarshal(arr[1], &e.Message) != nil {
+			e.Message = string(arr[1])
+		}
+		e.Code = int(code)
+		if len(arr) > 2 {
+			e.Data = arr[2]
+		}
+	default:
+		e.Message = strings.Trim(string(raw), `"`)
+	}
+	e.Kind = classifyRPCError(e.Code, e.Message)
+	return e
+}
+
+// classifyRPCError tells the category of an error from its code, for the codes
+// nodes and the stratum protocol define, or otherwise from its message.
+func classifyRPCError(code int, message string) rpcErrorKind {
+	msg := strings.ToLower(message)
+	contains := func(words ...string) bool {
+		for _, word := range words {
+			if strings.Contains(msg, word) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case code == -32005 || contains("rate limit", "too many requests", "limit exceeded"):
+		return rpcErrRateLimited
+	case code == 24 || code == 25 || contains("unauthori", "not authori", "forbidden", "invalid login", "not subscribed", "access denied"):
+		return rpcErrUnauthorized
+	case code == -32601 || contains("no mining work", "not mining", "mining not enabled", "method not found", "does not exist"):
+		return rpcErrNotMining
+	case contains("stale", "too old", "outdated", "expired", "obsolete"):
+		return rpcErrStale
+	case code == 21 || contains("unknown work", "unknown job", "job not found", "work not found", "no such job"):
+		return rpcErrUnknownWork
+	case code == 23 || contains("invalid pow", "invalid proof", "proof-of-work", "low difficulty", "invalid share", "bad share", "invalid solution", "invalid nonce", "mix digest", "above target"):
+		return rpcErrInvalidPoW
+	}
+	return rpcErrOther
+}
+
+// rpcErrorCounts tracks how often every category of error was reported.
+var rpcErrorCounts struct {
+	sync.Mutex
+	counts [rpcErrKinds]int
+}
+
+// logRPCError logs an error with the given prefix. Errors reported by an
+// endpoint are also counted, and logged with their category and count.
+func logRPCError(prefix string, err error) {
+	var (
+		callErr   *rpcCallError
+		statusErr *httpStatusError
+		kind      rpcErrorKind
+	)
+	switch {
+	case errors.As(err, &callErr):
+		kind = callErr.Kind
+	case errors.As(err, &statusErr) && errors.Is(err, errUnauthorized):
+		kind = rpcErrUnauthorized
+	case errors.As(err, &statusErr) && errors.Is(err, errRateLimited):
+		kind = rpcErrRateLimited
+	default:
+		log.Println(prefix, err)
+		return
+	}
+	rpcErrorCounts.Lock()
+	rpcErrorCounts.counts[kind]++
+	count := rpcErrorCounts.counts[kind]
+	rpcErrorCounts.Unlock()
+
+	log.Println(prefix, err, "| category:", kind, "| seen", count, "times")
+}
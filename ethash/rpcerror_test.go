@@ -0,0 +1,61 @@
+package ethash
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// Tests that errors are decoded from the object, array and string shapes nodes
+// and pools send, and classified by their code or message.
+func TestParseRPCError(t *testing.T) {
+	tests := []struct {
+		raw     string
+		code    int
+		message string
+		data    string
+		kind    rpcErrorKind
+	}{
+		// Node errors as JSON-RPC objects
+		{`{"code":-32000,"message":"invalid proof-of-work"}`, -32000, "invalid proof-of-work", "", rpcErrInvalidPoW},
+		{`{"code":-32005,"message":"request limit reached","data":{"retry":5}}`, -32005, "request limit reached", `{"retry":5}`, rpcErrRateLimited},
+		{`{"code":-32601,"message":"the method eth_getWork does not exist/is not available"}`, -32601, "the method eth_getWork does not exist/is not available", "", rpcErrNotMining},
+		{`{"code":-32000,"message":"no mining work available yet"}`, -32000, "no mining work available yet", "", rpcErrNotMining},
+		{`{"code":-32000,"message":"something else"}`, -32000, "something else", "", rpcErrOther},
+
+		// Stratum errors as [code, message, data] arrays
+		{`[21,"Job not found",null]`, 21, "Job not found", "null", rpcErrUnknownWork},
+		{`[23,"Low difficulty share"]`, 23, "Low difficulty share", "", rpcErrInvalidPoW},
+		{`[24,"Unauthorized worker",null]`, 24, "Unauthorized worker", "null", rpcErrUnauthorized},
+		{`[20,"Stale share",null]`, 20, "Stale share", "null", rpcErrStale},
+		{`[25,"Not subscribed"]`, 25, "Not subscribed", "", rpcErrUnauthorized},
+		{`[-1,{"reason":"odd"}]`, -1, `{"reason":"odd"}`, "", rpcErrOther},
+
+		// Plain strings
+		{`"Share is stale"`, 0, "Share is stale", "", rpcErrStale},
+		{`"Invalid login"`, 0, "Invalid login", "", rpcErrUnauthorized},
+		{`"Too many requests"`, 0, "Too many requests", "", rpcErrRateLimited},
+		{`"duplicate share"`, 0, "duplicate share", "", rpcErrOther},
+	}
+	for _, tt := range tests {
+		err := parseRPCError(json.RawMessage(tt.raw))
+		if err == nil {
+			t.Errorf("%s: no error decoded", tt.raw)
+			continue
+		}
+		if err.Code != tt.code || err.Message != tt.message || string(err.Data) != tt.data {
+			t.Errorf("%s: decoded as %d %q %s, want %d %q %s", tt.raw, err.Code, err.Message, err.Data, tt.code, tt.message, tt.data)
+		}
+		if err.Kind != tt.kind {
+			t.Errorf("%s: category %v, want %v", tt.raw, err.Kind, tt.kind)
+		}
+		if sentinel := rpcErrorSentinels[tt.kind]; sentinel != nil && !errors.Is(err, sentinel) {
+			t.Errorf("%s: doesn't match %v", tt.raw, sentinel)
+		}
+	}
+	for _, raw := range []string{``, `null`, `false`} {
+		if err := parseRPCError(json.RawMessage(raw)); err != nil {
+			t.Errorf("%q: decoded as error %v", raw, err)
+		}
+	}
+}
@@ -46,9 +46,16 @@ var (
 	errInvalidSealResult = errors.New("invalid or stale proof-of-work solution")
 )
 
+// SealResult is a block sealed by the local miner threads, together with the
+// seal hash of the work it was found for.
+type SealResult struct {
+	Block    *types.Block
+	SealHash common.Hash
+}
+
 // Seal implements consensus.Engine, attempting to find a nonce that satisfies
 // the block's difficulty requirements.
-func (ethash *Ethash) Seal(chain consensus.ChainHeaderReader, block *types.Block, results chan<- *types.Block, stop <-chan int, hash common.Hash) error {
+func (ethash *Ethash) Seal(chain consensus.ChainHeaderReader, block *types.Block, results chan<- *SealResult, stop <-chan int, hash common.Hash) error {
 	// If we're running a shared PoW, delegate sealing to it
 	if ethash.shared != nil {
 		return ethash.shared.Seal(chain, block, results, stop, hash)
@@ -58,7 +65,8 @@ func (ethash *Ethash) Seal(chain consensus.ChainHeaderReader, block *types.Block
 	abort := make(chan struct{})
 
 	ethash.lock.Lock()
-	threads := ethash.threads
+	threads, continuous := ethash.threads, ethash.continuous
+	prefix, mask := ethash.noncePrefix, ^uint64(0)>>ethash.noncePrefixBits
 	if ethash.rand == nil {
 		seed, err := crand.Int(crand.Reader, big.NewInt(math.MaxInt64))
 		if err != nil {
@@ -75,40 +83,54 @@ func (ethash *Ethash) Seal(chain consensus.ChainHeaderReader, block *types.Block
 
 	var (
 		pend   sync.WaitGroup
-		locals = make(chan *types.Block)
+		locals = make(chan *SealResult)
 	)
 
 	for i := 0; i < threads; i++ {
 		pend.Add(1)
 		go func(id int, nonce uint64) {
 			defer pend.Done()
-			ethash.mine(block, id, nonce, abort, locals, hash)
-		}(i, uint64(ethash.rand.Int63()))
+			ethash.mine(block, id, nonce, prefix, mask, continuous, abort, locals, hash)
+		}(i, prefix|uint64(ethash.rand.Int63())&mask)
 	}
 
 	// Wait until sealing is terminated or a nonce is found
 	go func() {
-		var result *types.Block
-		select {
-		case <-stop:
-			// Outside abort, stop all miner threads
-			abort <- struct{}{}
-			close(abort)
-		case result = <-locals:
-			// One of the threads found a block, abort all others
+		var result *SealResult
+	wait:
+		for {
 			select {
-			case results <- result:
-			default:
-				ethash.config.Log.Warn("Sealing result is not read by miner", "mode", "local", "sealhash", ethash.SealHash(block.Header()))
-			}
-			abort <- struct{}{}
-			close(abort)
-		case <-ethash.update:
-			// Thread count was changed on user request, restart
-			close(abort)
-			if err := ethash.Seal(chain, block, results, stop, hash); err != nil {
-				ethash.config.Log.Error("Failed to restart sealing after update", "err", err)
+			case <-stop:
+				// Outside abort, stop all miner threads
+				abort <- struct{}{}
+				close(abort)
+			case result = <-locals:
+				if continuous {
+					// Hand out the result and keep all threads searching
+					select {
+					case results <- result:
+						continue wait
+					case <-stop:
+						close(abort)
+						break wait
+					}
+				}
+				// One of the threads found a block, abort all others
+				select {
+				case results <- result:
+				default:
+					ethash.config.Log.Warn("Sealing result is not read by miner", "mode", "local", "sealhash", ethash.SealHash(block.Header()))
+				}
+				abort <- struct{}{}
+				close(abort)
+			case <-ethash.update:
+				// Thread count was changed on user request, restart
+				close(abort)
+				if err := ethash.Seal(chain, block, results, stop, hash); err != nil {
+					ethash.config.Log.Error("Failed to restart sealing after update", "err", err)
+				}
 			}
+			break
 		}
 		// Wait for all miners to terminate and return the block
 		pend.Wait()
@@ -117,13 +139,15 @@ func (ethash *Ethash) Seal(chain consensus.ChainHeaderReader, block *types.Block
 }
 
 // mine is the actual proof-of-work miner that searches for a nonce starting from
-// seed that results in correct final block difficulty.
-func (ethash *Ethash) mine(block *types.Block, id int, seed uint64, abort chan struct{}, found chan *types.Block, hashb common.Hash) {
+// seed that results in correct final block difficulty. Only the bits of the nonce
+// covered by mask are searched, the rest is fixed to prefix. In continuous mode
+// the search goes on after a nonce was found.
+func (ethash *Ethash) mine(block *types.Block, id int, seed uint64, prefix uint64, mask uint64, continuous bool, abort chan struct{}, found chan *SealResult, hashb common.Hash) {
 	// Extract some data from the header
 	var (
 		header  = block.Header()
 		hash    = hashb.Bytes()
-		target  = new(big.Int).Div(two256, header.Difficulty)
+		target  = newPowTarget(header.Difficulty)
 		number  = header.Number.Uint64()
 		dataset = ethash.dataset(number, false)
 	)
@@ -131,6 +155,7 @@ func (ethash *Ethash) mine(block *types.Block, id int, seed uint64, abort chan s
 	var (
 		attempts = int64(0)
 		nonce    = seed
+		search   = new(hashimotoContext)
 	)
 	logger := ethash.config.Log.New("miner", id)
 	logger.Trace("Started ethash search for new nonces", "seed", seed)
@@ -151,8 +176,8 @@ search:
 				attempts = 0
 			}
 			// Compute the PoW value of this nonce
-			digest, result := hashimotoFull(dataset.dataset, hash, nonce)
-			if new(big.Int).SetBytes(result).Cmp(target) <= 0 {
+			digest, result := search.hashimotoFull(dataset.dataset, hash, nonce)
+			if target.accepts(result) {
 				// Correct nonce found, create a new header with it
 				header = types.CopyHeader(header)
 				header.Nonce = types.EncodeNonce(nonce)
@@ -160,14 +185,17 @@ search:
 
 				// Seal and return a block (if still needed)
 				select {
-				case found <- block.WithSeal(header):
+				case found <- &SealResult{Block: block.WithSeal(header), SealHash: hashb}:
 					logger.Trace("Ethash nonce found and reported", "attempts", nonce-seed, "nonce", nonce)
 				case <-abort:
 					logger.Trace("Ethash nonce found but discarded", "attempts", nonce-seed, "nonce", nonce)
+					break search
+				}
+				if !continuous {
+					break search
 				}
-				break search
 			}
-			nonce++
+			nonce = prefix | (nonce+1)&mask
 		}
 	}
 	// Datasets are unmapped in a finalizer. Ensure that the dataset stays live
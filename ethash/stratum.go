@@ -0,0 +1,551 @@
+package ethash
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	stratumDialTimeout    = 10 * time.Second // Timeout for establishing a pool connection
+	stratumRequestTimeout = 10 * time.Second // Timeout for a pool to answer a request
+	minConnectionLifetime = 30 * time.Second // Time a connection has to last to not count as failed
+)
+
+var (
+	errStratumClosed  = errors.New("stratum connection closed")
+	errStratumTimeout = errors.New("stratum request timed out")
+
+	// stratumDiff1Target is the share target of difficulty 1 as defined by
+	// EthereumStratum/1.0.0, 0x00000000ffff0000...0000.
+	stratumDiff1Target = new(big.Int).Lsh(big.NewInt(0xffff), 208)
+)
+
+// stratumMessage is anything received over a stratum connection, either the
+// response to one of our requests or a notification pushed by the pool.
+type stratumMessage struct {
+	Id     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  json.RawMessage `json:"error"`
+}
+
+// stratumConn is a line delimited JSON-RPC connection as used by the stratum
+// family of mining protocols (EthereumStratum/1.0.0 and eth-proxy). Responses
+// are matched to their requests by id, every other message is handed to the
+// notification handler.
+type stratumConn struct {
+	conn   net.Conn
+	notify func(msg *stratumMessage)
+
+	lock    sync.Mutex
+	nextId  int
+	pending map[int]chan *stratumMessage
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// dialStratum connects to the pool behind the given URL, through proxy if not
+// nil and over TLS for +ssl and +tls schemes, and starts reading its messages,
+// passing notifications to notify from the read loop.
+func dialStratum(u *url.URL, proxy *url.URL, notify func(msg *stratumMessage)) (*stratumConn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), stratumDialTimeout)
+	defer cancel()
+
+	conn, err := dialThrough(ctx, new(net.Dialer), proxy, u.Host)
+	if err != nil {
+		return nil, err
+	}
+	if isTLSURL(u) {
+		config := new(tls.Config)
+		if tlsConfig != nil {
+			config = tlsConfig.Clone()
+		}
+		config.ServerName = u.Hostname()
+
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	return newStratumConn(conn, notify), nil
+}
+
+// newStratumConn starts reading the messages of an established connection,
+// passing notifications to notify from the read loop.
+func newStratumConn(conn net.Conn, notify func(msg *stratumMessage)) *stratumConn {
+	c := &stratumConn{
+		conn:    conn,
+		notify:  notify,
+		pending: make(map[int]chan *stratumMessage),
+		closed:  make(chan struct{}),
+	}
+	go c.loop()
+	return c
+}
+
+// loop reads messages from the pool until the connection breaks.
+func (c *stratumConn) loop() {
+	defer c.close()
+
+	reader := bufio.NewReader(c.conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			log.Println("Connection to", c.conn.RemoteAddr(), "lost:", err)
+			return
+		}
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		msg := new(stratumMessage)
+		if err := json.Unmarshal(line, msg); err != nil {
+			log.Println("Invalid message from", c.conn.RemoteAddr(), "-", err)
+			continue
+		}
+		if msg.Method == "" {
+			if id, err := strconv.Atoi(strings.Trim(string(msg.Id), `"`)); err == nil {
+				c.lock.Lock()
+				res, ok := c.pending[id]
+				delete(c.pending, id)
+				c.lock.Unlock()
+
+				if ok {
+					res <- msg
+					continue
+				}
+			}
+		}
+		c.notify(msg)
+	}
+}
+
+// call sends a request to the pool and waits for its response.
+func (c *stratumConn) call(req *RpcInfo) (*stratumMessage, error) {
+	res := make(chan *stratumMessage, 1)
+
+	c.lock.Lock()
+	c.nextId++
+	req.Id = c.nextId
+	c.pending[req.Id] = res
+	c.lock.Unlock()
+
+	defer func() {
+		c.lock.Lock()
+		delete(c.pending, req.Id)
+		c.lock.Unlock()
+	}()
+
+	if err := c.write(req); err != nil {
+		return nil, err
+	}
+	timeout := time.NewTimer(stratumRequestTimeout)
+	defer timeout.Stop()
+
+	select {
+	case msg := <-res:
+		return msg, nil
+	case <-c.closed:
+		return nil, errStratumClosed
+	case <-timeout.C:
+		return nil, errStratumTimeout
+	}
+}
+
+// submit sends a share or solution, as told by kind, to the pool and logs its
+// answer, returning whether it was accepted.
+func (c *stratumConn) submit(kind string, req *RpcInfo) bool {
+	res, err := c.call(req)
+	if err != nil {
+		log.Println(kind, "submission failed:", err)
+		return false
+	}
+	var accepted bool
+	if json.Unmarshal(res.Result, &accepted); accepted {
+		log.Println(kind + " accepted.")
+	} else if err := parseRPCError(res.Error); err != nil {
+		logRPCError(kind+" rejected:", err)
+	} else {
+		log.Println(kind + " rejected.")
+	}
+	return accepted
+}
+
+// send sends a request to the pool without waiting for its response, which is
+// handed to the notification handler in order with everything else.
+func (c *stratumConn) send(req *RpcInfo) error {
+	c.lock.Lock()
+	c.nextId++
+	req.Id = c.nextId
+	c.lock.Unlock()
+
+	return c.write(req)
+}
+
+// write serializes a request onto the connection.
+func (c *stratumConn) write(req *RpcInfo) error {
+	blob, _ := json.Marshal(req)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(stratumRequestTimeout))
+	if _, err := c.conn.Write(append(blob, '\n')); err != nil {
+		c.close()
+		return err
+	}
+	return nil
+}
+
+// close tears down the connection, failing all pending requests.
+func (c *stratumConn) close() {
+	c.closeOnce.Do(func() {
+		c.conn.Close()
+		close(c.closed)
+	})
+}
+
+// keepConnected maintains a pool connection established by connect until quit
+// is closed, giving up once maxEndpointFailures connection attempts in a row
+// failed. Connections closed within minConnectionLifetime count as failed, so
+// endpoints dropping every connection right away are given up on as well.
+func keepConnected(protocol string, connect func() (*stratumConn, error), quit <-chan struct{}) error {
+	var (
+		failures int
+		retry    = backoff{min: retryMinDelay, max: retryMaxDelay}
+	)
+	for {
+		conn, err := connect()
+		if err == nil {
+			connected := time.Now()
+			select {
+			case <-conn.closed:
+			case <-quit:
+				conn.close()
+				return nil
+			}
+			if lifetime := time.Since(connected); lifetime < minConnectionLifetime {
+				err = fmt.Errorf("connection closed after %v", lifetime.Round(time.Millisecond))
+			} else {
+				failures = 0
+				retry.reset()
+			}
+		}
+		if err != nil {
+			log.Println(protocol, "connection failed:", err)
+			if failures++; failures >= maxEndpointFailures {
+				return err
+			}
+		}
+		select {
+		case <-time.After(retry.next()):
+		case <-quit:
+			return nil
+		}
+	}
+}
+
+// probeStratum checks whether the pool behind the given URL accepts connections,
+// through proxy if not nil.
+func probeStratum(u *url.URL, proxy *url.URL) error {
+	ctx, cancel := context.WithTimeout(context.Background(), stratumDialTimeout)
+	defer cancel()
+
+	conn, err := dialThrough(ctx, new(net.Dialer), proxy, u.Host)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// stratumError renders the message of the error member of a stratum response.
+func stratumError(raw json.RawMessage) string {
+	if err := parseRPCError(raw); err != nil {
+		return err.Message
+	}
+	return ""
+}
+
+// stratumClient is the work source for pools speaking EthereumStratum/1.0.0
+// (NiceHash stratum). Jobs are pushed by the pool with mining.notify, and the
+// share target is set with mining.set_difficulty.
+type stratumClient struct {
+	url      *url.URL
+	proxy    *url.URL // Proxy to connect through, nil for none
+	login    string
+	password string
+
+	lock       sync.Mutex
+	conn       *stratumConn
+	extraNonce string   // Hex encoded nonce prefix assigned by the pool
+	difficulty *big.Int // Ethash difficulty equivalent of the share target
+	job        *Work    // Last job pushed by the pool
+	getWork    chan<- Work
+	quit       <-chan struct{}
+}
+
+// newStratumClient creates a stratum work source for a stratum+tcp:// URL. The
+// login and password are taken from the URL user info, defaulting to the wallet
+// address with the worker name and "x".
+func newStratumClient(rawurl string, login poolLogin, proxy *url.URL) (*stratumClient, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, errors.New("missing pool host")
+	}
+	c := &stratumClient{
+		url:        u,
+		proxy:      proxy,
+		login:      login.stratumLogin(),
+		password:   "x",
+		difficulty: stratumDifficulty(1),
+	}
+	if u.User != nil {
+		c.login = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			c.password = password
+		}
+	}
+	if c.login == "" {
+		return nil, errors.New("missing pool login")
+	}
+	return c, nil
+}
+
+func (c *stratumClient) Run(getWork chan<- Work, quit <-chan struct{}) error {
+	c.lock.Lock()
+	c.getWork, c.quit, c.job = getWork, quit, nil
+	c.lock.Unlock()
+
+	return keepConnected("Stratum", c.connect, quit)
+}
+
+func (c *stratumClient) Probe() error {
+	return probeStratum(c.url, c.proxy)
+}
+
+// deliver hands a job to the miner, unless the client is being stopped.
+func (c *stratumClient) deliver(work Work) {
+	c.lock.Lock()
+	getWork, quit := c.getWork, c.quit
+	work.noncePrefix, work.nonceBits = extraNoncePrefix(c.extraNonce)
+	c.lock.Unlock()
+
+	select {
+	case getWork <- work:
+	case <-quit:
+	}
+}
+
+// connect dials the pool, subscribes to jobs and authorizes the worker.
+func (c *stratumClient) connect() (*stratumConn, error) {
+	conn, err := dialStratum(c.url, c.proxy, c.handle)
+	if err != nil {
+		return nil, err
+	}
+	res, err := conn.call(&RpcInfo{Method: "mining.subscribe", Params: []interface{}{"ethashcpu/1.0.0", "EthereumStratum/1.0.0"}})
+	if err != nil {
+		conn.close()
+		return nil, err
+	}
+	var subscription []json.RawMessage
+	if err := json.Unmarshal(res.Result, &subscription); err != nil || len(subscription) < 2 {
+		conn.close()
+		return nil, fmt.Errorf("subscription failed: %s", stratumError(res.Error))
+	}
+	var extraNonce string
+	if err := json.Unmarshal(subscription[1], &extraNonce); err != nil {
+		conn.close()
+		return nil, fmt.Errorf("invalid extranonce: %s", subscription[1])
+	}
+	if err := c.setExtraNonce(extraNonce); err != nil {
+		conn.close()
+		return nil, err
+	}
+	res, err = conn.call(&RpcInfo{Method: "mining.authorize", Params: []interface{}{c.login, c.password}})
+	if err != nil {
+		conn.close()
+		return nil, err
+	}
+	var authorized bool
+	if json.Unmarshal(res.Result, &authorized); !authorized {
+		conn.close()
+		return nil, fmt.Errorf("authorization failed: %s", stratumError(res.Error))
+	}
+	c.lock.Lock()
+	c.conn = conn
+	c.lock.Unlock()
+
+	log.Println("Connected to stratum pool", c.url.Host, "as", c.login)
+
+	go reportHashrate(poolHashrateInterval, func(params []interface{}) error {
+		return submitPoolHashrate(conn, "", params)
+	}, conn.closed)
+
+	// Pools not supporting extranonce changes answer with an error or not at all
+	go func() {
+		if res, err := conn.call(&RpcInfo{Method: "mining.extranonce.subscribe", Params: []interface{}{}}); err == nil && stratumError(res.Error) != "" {
+			log.Println("Pool does not support extranonce changes:", stratumError(res.Error))
+		}
+	}()
+	return conn, nil
+}
+
+// handle processes the notifications pushed by the pool.
+func (c *stratumClient) handle(msg *stratumMessage) {
+	switch msg.Method {
+	case "mining.notify":
+		// params: [job id, seed hash, header hash, clean jobs]
+		var params []interface{}
+		if err := json.Unmarshal(msg.Params, &params); err != nil || len(params) < 3 {
+			log.Println("Invalid stratum job:", string(msg.Params))
+			return
+		}
+		job, _ := params[0].(string)
+		seed, _ := params[1].(string)
+		hash, _ := params[2].(string)
+
+		// Pools send the header hash with or without the 0x prefix
+		if blob, err := hex.DecodeString(strings.TrimPrefix(hash, "0x")); err != nil || len(blob) != common.HashLength {
+			log.Println("Invalid header hash in stratum job:", hash)
+			return
+		}
+
+		epoch, ok := seedEpoch(common.FromHex(seed))
+		if !ok {
+			log.Println("Unknown seed hash in stratum job:", seed)
+			return
+		}
+		c.lock.Lock()
+		work := Work{
+			Header: &types.Header{
+				Number:     new(big.Int).SetUint64(epoch * epochLength),
+				Difficulty: c.difficulty,
+			},
+			Hash: common.HexToHash(hash).Hex(),
+			Job:  job,
+		}
+		c.job = &work
+		c.lock.Unlock()
+
+		c.deliver(work)
+
+	case "mining.set_difficulty":
+		var params []float64
+		if err := json.Unmarshal(msg.Params, &params); err != nil || len(params) < 1 {
+			log.Println("Invalid stratum difficulty:", string(msg.Params))
+			return
+		}
+		c.lock.Lock()
+		c.difficulty = stratumDifficulty(params[0])
+		c.lock.Unlock()
+
+		log.Println("Stratum share difficulty set to", params[0])
+
+	case "mining.set_extranonce":
+		var params []string
+		if err := json.Unmarshal(msg.Params, &params); err != nil || len(params) < 1 {
+			log.Println("Invalid stratum extranonce:", string(msg.Params))
+			return
+		}
+		if err := c.setExtraNonce(params[0]); err != nil {
+			log.Println("Invalid stratum extranonce:", err)
+		}
+
+	default:
+		if msg.Method != "" {
+			log.Println("Unsupported stratum method:", msg.Method)
+		}
+	}
+}
+
+// setExtraNonce fixes the top nonce bits searched for the pool's jobs to the
+// prefix it assigned, restarting the current job if the prefix changed.
+func (c *stratumClient) setExtraNonce(extraNonce string) error {
+	if len(extraNonce) > 12 {
+		return fmt.Errorf("extranonce too long: %s", extraNonce)
+	}
+	if extraNonce != "" {
+		if _, err := strconv.ParseUint(extraNonce, 16, 64); err != nil {
+			return fmt.Errorf("invalid extranonce: %s", extraNonce)
+		}
+	}
+	c.lock.Lock()
+	changed := c.extraNonce != strings.ToLower(extraNonce)
+	c.extraNonce = strings.ToLower(extraNonce)
+	job := c.job
+	c.lock.Unlock()
+
+	if changed && job != nil {
+		c.deliver(*job)
+	}
+	return nil
+}
+
+// extraNoncePrefix returns the nonce prefix and its number of bits for a valid
+// extranonce. The prefix travels with the jobs, as racing pools each assign
+// their own.
+func extraNoncePrefix(extraNonce string) (uint64, uint) {
+	if extraNonce == "" {
+		return 0, 0
+	}
+	n, _ := strconv.ParseUint(extraNonce, 16, 64)
+	bits := 4 * uint(len(extraNonce))
+	return n << (64 - bits), bits
+}
+
+func (c *stratumClient) Submit(work Work, nonce types.BlockNonce, mixDigest common.Hash) bool {
+	c.lock.Lock()
+	conn, extraNonce := c.conn, c.extraNonce
+	c.lock.Unlock()
+
+	if conn == nil {
+		log.Println("Share discarded, not connected to pool.")
+		return false
+	}
+	// The pool only wants the part of the nonce that we searched
+	nonceHex := fmt.Sprintf("%016x", nonce.Uint64())
+	if !strings.HasPrefix(nonceHex, extraNonce) {
+		log.Println("Share discarded, extranonce changed.")
+		return false
+	}
+	return conn.submit("Share", &RpcInfo{Method: "mining.submit", Params: []interface{}{c.login, work.Job, nonceHex[len(extraNonce):]}})
+}
+
+// stratumDifficulty converts a stratum share difficulty into the ethash block
+// difficulty with the same target. Targets beyond 256 bits are capped, which
+// makes the difficulty at least 1.
+func stratumDifficulty(diff float64) *big.Int {
+	if diff <= 0 {
+		diff = 1
+	}
+	target, _ := new(big.Float).Quo(new(big.Float).SetInt(stratumDiff1Target), big.NewFloat(diff)).Int(nil)
+	if target.Sign() <= 0 {
+		target.SetUint64(1)
+	}
+	if target.BitLen() > 256 {
+		target.Sub(two256, common.Big1)
+	}
+	return new(big.Int).Div(two256, target)
+}
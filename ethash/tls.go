@@ -0,0 +1,94 @@
+package ethash
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// pinPrefix starts a certificate pin, the base64 encoded SHA-256 hash of a
+// certificate's public key (SPKI) as accepted by curl's --pinnedpubkey.
+const pinPrefix = "sha256//"
+
+// tlsConfig is used for all outbound TLS connections, nil for the defaults.
+var tlsConfig *tls.Config
+
+// newTLSConfig creates the TLS configuration from the options, nil if they
+// don't change the defaults.
+func newTLSConfig(options Options) (*tls.Config, error) {
+	if options.CACert == "" && options.ClientCert == "" && options.ClientKey == "" && len(options.Pins) == 0 {
+		return nil, nil
+	}
+	config := new(tls.Config)
+	if options.CACert != "" {
+		blob, err := os.ReadFile(options.CACert)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(blob) {
+			return nil, fmt.Errorf("no PEM encoded certificates in %s", options.CACert)
+		}
+	}
+	if options.ClientCert != "" || options.ClientKey != "" {
+		if options.ClientCert == "" || options.ClientKey == "" {
+			return nil, errors.New("a client certificate needs both the certificate and the key")
+		}
+		cert, err := tls.LoadX509KeyPair(options.ClientCert, options.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if len(options.Pins) > 0 {
+		pins := make(map[string]bool)
+		for _, list := range options.Pins {
+			for _, pin := range strings.Split(list, ";") {
+				pin = strings.TrimSpace(pin)
+				hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, pinPrefix))
+				if !strings.HasPrefix(pin, pinPrefix) || err != nil || len(hash) != sha256.Size {
+					return nil, fmt.Errorf("invalid certificate pin %q, expected %s<base64 encoded SHA-256 hash>", pin, pinPrefix)
+				}
+				pins[string(hash)] = true
+			}
+		}
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			return verifyPins(state, pins)
+		}
+	}
+	return config, nil
+}
+
+// verifyPins checks that one of the certificates the server presented has a
+// pinned public key. The chain has been verified already, so pinning an
+// intermediate or root CA works as well.
+func verifyPins(state tls.ConnectionState, pins map[string]bool) error {
+	for _, cert := range state.PeerCertificates {
+		if hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo); pins[string(hash[:])] {
+			return nil
+		}
+	}
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("no server certificate to check the pins against")
+	}
+	hash := sha256.Sum256(state.PeerCertificates[0].RawSubjectPublicKeyInfo)
+	return fmt.Errorf("server certificate doesn't match any pin, its public key is %s%s", pinPrefix, base64.StdEncoding.EncodeToString(hash[:]))
+}
+
+// configureTLS makes all outbound connections use the given TLS configuration.
+func configureTLS(config *tls.Config) {
+	tlsConfig = config
+	httpClient.Transport = newHTTPClientTransport(nil)
+}
+
+// isTLSURL reports whether a TCP pool URL asks for TLS, with an +ssl or +tls
+// scheme like stratum+ssl://.
+func isTLSURL(u *url.URL) bool {
+	return strings.HasSuffix(u.Scheme, "+ssl") || strings.HasSuffix(u.Scheme, "+tls")
+}
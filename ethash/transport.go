@@ -0,0 +1,319 @@
+package ethash
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const rpcRequestTimeout = 10 * time.Second // Timeout for the node to answer a call
+
+var (
+	errConnClosed     = errors.New("connection to node closed")
+	errRequestTimeout = errors.New("request to node timed out")
+)
+
+// rpcTransport carries JSON-RPC calls to a node.
+type rpcTransport interface {
+	// call sends a request to the node and returns the encoded response.
+	call(req *RpcInfo) ([]byte, error)
+}
+
+// subscriber is implemented by transports over which the node can push
+// notifications for eth_subscribe subscriptions.
+type subscriber interface {
+	// subscribe creates a subscription and hands every notification to notify.
+	// The returned channel is closed once the subscription is lost.
+	subscribe(params []interface{}, notify func(result json.RawMessage)) (<-chan struct{}, error)
+}
+
+// newTransport picks the transport to reach the node at rawurl: a recording to
+// replay, a path to an IPC socket, a WebSocket URL or otherwise plain HTTP. HTTP
+// endpoints get the pool login in their URL, HTTP and WebSocket endpoints in the
+// login header. HTTP and WebSocket calls are authenticated with auth, taking a
+// basic auth login from the URL, and go through proxy if not nil.
+func newTransport(rawurl string, login poolLogin, auth *rpcAuth, proxy *url.URL) (rpcTransport, error) {
+	if !strings.HasPrefix(rawurl, "replay:") && !isIPCPath(rawurl) {
+		auth, rawurl = auth.forURL(rawurl)
+	}
+	switch {
+	case strings.HasPrefix(rawurl, "replay:"):
+		path, endpoint, _ := strings.Cut(strings.TrimPrefix(rawurl, "replay:"), "#")
+		return openReplay(path, endpoint)
+	case isIPCPath(rawurl):
+		path := strings.TrimPrefix(rawurl, "ipc://")
+		return newConnTransport("IPC", func() (rpcCodec, error) { return dialIPC(path) }), nil
+	case strings.HasPrefix(rawurl, "ws://"), strings.HasPrefix(rawurl, "wss://"):
+		return newConnTransport("WebSocket", func() (rpcCodec, error) {
+			header := login.headers()
+			auth.apply(header)
+			return dialWS(rawurl, header, proxy)
+		}), nil
+	}
+	// Connections through a proxy can't be shared with direct ones
+	client := httpClient
+	if proxy != nil {
+		client = &http.Client{Transport: newHTTPClientTransport(proxy)}
+	}
+	return &httpTransport{url: login.url(rawurl), header: login.headers(), auth: auth, client: client}, nil
+}
+
+// httpClient is shared by all HTTP endpoints, keeping connections to the nodes
+// alive between calls.
+var httpClient = &http.Client{Transport: newHTTPClientTransport(nil)}
+
+// newHTTPClientTransport creates the transport of an HTTP client connecting
+// through proxy, or directly if nil.
+func newHTTPClientTransport(proxy *url.URL) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 4
+	transport.TLSClientConfig = tlsConfig.Clone()
+
+	// Proxies are set per endpoint, tunnelling every connection
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network string, addr string) (net.Conn, error) {
+		return dialThrough(ctx, dialer, proxy, addr)
+	}
+	return transport
+}
+
+// httpTransport sends every call as a separate HTTP POST request.
+type httpTransport struct {
+	url    string
+	header http.Header // Extra headers sent with every request
+	auth   *rpcAuth
+	client *http.Client
+}
+
+func (t *httpTransport) call(req *RpcInfo) ([]byte, error) {
+	blob, _ := json.Marshal(req)
+
+	ctx, cancel := context.WithTimeout(context.Background(), rpcRequestTimeout)
+	defer cancel()
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", t.url, bytes.NewBuffer(blob))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range t.header {
+		httpReq.Header[name] = values
+	}
+	t.auth.apply(httpReq.Header)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(httpReq)
+	if err != nil {
+		return nil, httpError(err)
+	}
+	defer resp.Body.Close()
+
+	// The body has to be drained for the connection to be reused
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, httpError(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &httpStatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+	return body, nil
+}
+
+// httpError replaces the error of a request that ran out of time with
+// errRequestTimeout.
+func httpError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return errRequestTimeout
+	}
+	return err
+}
+
+// rpcCodec reads and writes single JSON-RPC messages on a persistent connection.
+type rpcCodec interface {
+	readMessage() ([]byte, error)
+	writeMessage(blob []byte) error
+	close() error
+}
+
+// rpcMessage is anything received from the node over a persistent connection,
+// either the response to a call or an eth_subscription notification.
+type rpcMessage struct {
+	Id     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params struct {
+		Subscription string          `json:"subscription"`
+		Result       json.RawMessage `json:"result"`
+	} `json:"params"`
+}
+
+// connTransport multiplexes calls and subscriptions over a single persistent
+// connection to the node, redialing it on the next call once it's lost.
+type connTransport struct {
+	name string
+	dial func() (rpcCodec, error)
+
+	lock    sync.Mutex
+	codec   rpcCodec
+	closed  chan struct{} // Closed when the current connection is lost
+	nextId  int
+	pending map[int]chan []byte
+	subs    map[string]func(result json.RawMessage)
+
+	writeLock sync.Mutex // Serializes writes of concurrent calls
+}
+
+func newConnTransport(name string, dial func() (rpcCodec, error)) *connTransport {
+	return &connTransport{name: name, dial: dial}
+}
+
+// connect returns the current connection, dialing a new one if there's none.
+func (t *connTransport) connect() (rpcCodec, chan struct{}, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.codec != nil {
+		return t.codec, t.closed, nil
+	}
+	codec, err := t.dial()
+	if err != nil {
+		return nil, nil, err
+	}
+	t.codec = codec
+	t.closed = make(chan struct{})
+	t.pending = make(map[int]chan []byte)
+	t.subs = make(map[string]func(result json.RawMessage))
+
+	go t.loop(codec, t.closed)
+	return codec, t.closed, nil
+}
+
+// loop reads messages from the node until the connection breaks.
+func (t *connTransport) loop(codec rpcCodec, closed chan struct{}) {
+	defer func() {
+		codec.close()
+
+		t.lock.Lock()
+		if t.codec == codec {
+			t.codec = nil
+		}
+		t.lock.Unlock()
+
+		close(closed)
+	}()
+	for {
+		blob, err := codec.readMessage()
+		if err != nil {
+			log.Println(t.name, "connection lost:", err)
+			return
+		}
+		msg := new(rpcMessage)
+		if err := json.Unmarshal(blob, msg); err != nil {
+			log.Println("Invalid", t.name, "message:", err)
+			continue
+		}
+		if msg.Method == "eth_subscription" {
+			t.lock.Lock()
+			notify := t.subs[msg.Params.Subscription]
+			t.lock.Unlock()
+
+			if notify != nil {
+				notify(msg.Params.Result)
+			}
+			continue
+		}
+		if id, err := strconv.Atoi(strings.Trim(string(msg.Id), `"`)); err == nil {
+			t.lock.Lock()
+			res, ok := t.pending[id]
+			delete(t.pending, id)
+			t.lock.Unlock()
+
+			if ok {
+				res <- blob
+			}
+		}
+	}
+}
+
+func (t *connTransport) call(req *RpcInfo) ([]byte, error) {
+	codec, closed, err := t.connect()
+	if err != nil {
+		return nil, err
+	}
+	res := make(chan []byte, 1)
+
+	// Calls are shared between goroutines, so ids have to be unique
+	msg := *req
+	t.lock.Lock()
+	t.nextId++
+	msg.Id = t.nextId
+	t.pending[msg.Id] = res
+	t.lock.Unlock()
+
+	defer func() {
+		t.lock.Lock()
+		delete(t.pending, msg.Id)
+		t.lock.Unlock()
+	}()
+
+	blob, _ := json.Marshal(&msg)
+
+	t.writeLock.Lock()
+	err = codec.writeMessage(blob)
+	t.writeLock.Unlock()
+
+	if err != nil {
+		codec.close()
+		return nil, err
+	}
+	timeout := time.NewTimer(rpcRequestTimeout)
+	defer timeout.Stop()
+
+	select {
+	case blob := <-res:
+		return blob, nil
+	case <-closed:
+		return nil, errConnClosed
+	case <-timeout.C:
+		return nil, errRequestTimeout
+	}
+}
+
+func (t *connTransport) subscribe(params []interface{}, notify func(result json.RawMessage)) (<-chan struct{}, error) {
+	_, closed, err := t.connect()
+	if err != nil {
+		return nil, err
+	}
+	blob, err := t.call(&RpcInfo{Jsonrpc: "2.0", Method: "eth_subscribe", Params: params})
+	if err != nil {
+		return nil, err
+	}
+	var res struct {
+		Result string          `json:"result"`
+		Error  json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(blob, &res); err != nil {
+		return nil, err
+	}
+	if res.Result == "" {
+		return nil, errors.New("subscription refused: " + stratumError(res.Error))
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// Make sure the subscription was created on the connection still in use
+	if t.codec == nil || t.closed != closed {
+		return nil, errConnClosed
+	}
+	t.subs[res.Result] = notify
+	return closed, nil
+}
@@ -0,0 +1,49 @@
+package ethash
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const wsDialTimeout = 10 * time.Second // Timeout for establishing a WebSocket connection
+
+// wsCodec frames JSON-RPC messages as WebSocket text messages.
+type wsCodec struct {
+	conn *websocket.Conn
+}
+
+// dialWS opens a WebSocket connection to the node, through proxy if not nil,
+// sending the given headers with the handshake.
+func dialWS(rawurl string, header http.Header, proxy *url.URL) (rpcCodec, error) {
+	dialer := websocket.Dialer{
+		HandshakeTimeout: wsDialTimeout,
+		TLSClientConfig:  tlsConfig,
+		NetDialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			return dialThrough(ctx, new(net.Dialer), proxy, addr)
+		},
+	}
+	conn, _, err := dialer.Dial(rawurl, header)
+	if err != nil {
+		return nil, err
+	}
+	return &wsCodec{conn: conn}, nil
+}
+
+func (c *wsCodec) readMessage() ([]byte, error) {
+	_, blob, err := c.conn.ReadMessage()
+	return blob, err
+}
+
+func (c *wsCodec) writeMessage(blob []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(rpcRequestTimeout))
+	return c.conn.WriteMessage(websocket.TextMessage, blob)
+}
+
+func (c *wsCodec) close() error {
+	return c.conn.Close()
+}
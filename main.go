@@ -2,24 +2,66 @@ package main
 
 import (
 	"ethashcpu/ethash"
+	"flag"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 )
 
+// repeatedFlag collects the values of a flag that may be given multiple times.
+type repeatedFlag []string
+
+func (r *repeatedFlag) String() string {
+	return strings.Join(*r, ", ")
+}
+
+func (r *repeatedFlag) Set(value string) error {
+	*r = append(*r, value)
+	return nil
+}
+
 func main() {
-	if len(os.Args) < 3 {
-		println("Usage: cpuminer [rpcUrl] [threads]")
+	var options ethash.Options
+	flag.StringVar(&options.NotifyAddr, "notify", "", "Listen address for work pushed by a node's --miner.notify (e.g. 127.0.0.1:8090)")
+	flag.BoolVar(&options.Race, "race", false, "Fetch work from all endpoints at once and mine the highest block seen first")
+	flag.BoolVar(&options.Broadcast, "broadcast", false, "Submit solutions to all endpoints in parallel")
+	flag.IntVar(&options.MinPeers, "minpeers", 0, "Pause mining while the node has fewer peers, 0 to not check")
+	flag.DurationVar(&options.MaxBlockAge, "maxblockage", 0, "Pause mining while the node's latest block is older, 0 to not check")
+	flag.BoolVar(&options.Audit, "audit", false, "Check work from nodes against their pending block and log mismatches")
+	flag.BoolVar(&options.AuditStop, "auditstop", false, "Like -audit, and stop mining on a node whose work failed the check")
+	flag.StringVar(&options.AuditGenesis, "auditgenesis", "", "Genesis file of the chain whose difficulty rules the audit checks against, for chains other than the Ethereum mainnet")
+	flag.StringVar(&options.Worker, "worker", "", "Worker name reported to pools (getWork pools default to \"1\")")
+	flag.StringVar(&options.URLTemplate, "urltemplate", ethash.DefaultURLTemplate, "URL of getWork pools, with {url}, {address} and {worker} placeholders")
+	flag.StringVar(&options.LoginHeader, "loginheader", "", "Header sending the login to getWork pools, e.g. \"X-Login: {address}.{worker}\"")
+	flag.StringVar(&options.Record, "record", "", "Append every call to getWork endpoints with its response to this file, for replaying with replay:file")
+	flag.StringVar(&options.BearerToken, "bearer", "", "Bearer token to authenticate to nodes with")
+	flag.StringVar(&options.JWTSecret, "jwtsecret", "", "File with the hex encoded secret to sign JWTs for nodes with authenticated RPC")
+	flag.Var((*repeatedFlag)(&options.Headers), "header", "Header to send to nodes as \"Name: value\", may be repeated")
+	flag.StringVar(&options.CACert, "cacert", "", "PEM file with the CA certificates to trust for TLS instead of the system ones")
+	flag.StringVar(&options.ClientCert, "cert", "", "PEM file with the client certificate for mutual TLS")
+	flag.StringVar(&options.ClientKey, "key", "", "PEM file with the key of the client certificate")
+	flag.Var((*repeatedFlag)(&options.Pins), "pin", "Accepted public key of TLS servers as sha256//<base64 SPKI hash>, may be repeated")
+	flag.StringVar(&options.Proxy, "proxy", "", "HTTP or SOCKS5 proxy for endpoints without a #proxy= suffix, \"direct\" to ignore HTTP_PROXY and ALL_PROXY")
+	flag.Usage = func() {
+		println("Usage: cpuminer [options] [rpcUrl] [threads] [address]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 2 {
+		flag.Usage()
 		return
 	}
 
 	thirdArg := ""
 
-	if len(os.Args) == 4 {
-		thirdArg = os.Args[3]
+	if len(args) == 3 {
+		thirdArg = args[2]
 	}
 
-	ethash.Start(os.Args[1], os.Args[2], thirdArg)
+	ethash.Start(args[0], args[1], thirdArg, options)
 
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, os.Kill)
@@ -4,6 +4,7 @@ import (
 	"math/big"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -99,3 +100,16 @@ func String2Big(num string) *big.Int {
 	n.SetString(num, 0)
 	return n
 }
+
+// IsValidChecksumAddress reports whether s is a valid hex address that, if it's
+// written in mixed case, carries a valid EIP-55 checksum.
+func IsValidChecksumAddress(s string) bool {
+	if !IsValidHexAddress(s) {
+		return false
+	}
+	digits := s[2:]
+	if digits == strings.ToLower(digits) || digits == strings.ToUpper(digits) {
+		return true
+	}
+	return common.HexToAddress(s).Hex() == s
+}